@@ -2,14 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -18,32 +21,328 @@ import (
 	"github.com/clambin/github-stars/internal/github"
 	"github.com/clambin/github-stars/internal/stars"
 	"github.com/clambin/github-stars/slogctx"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var version = "(devel)"
 
+const githubCacheFilename = "github-cache.json"
+
+// notifyAttempts is the default maximum number of attempts to deliver a notification.
+const notifyAttempts = 10
+
 type configuration struct {
 	flagger.Log
 	flagger.Prom
-	GitHub    githubConfiguration
-	Slack     slackConfiguration
-	Directory string `flagger.usage:"database directory"`
-	User      string `flagger.usage:"user to scan for repositories"`
-	Archived  bool   `flagger.usage:"include archived repositories"`
+	GitHub     githubConfiguration
+	Enterprise enterpriseConfiguration
+	Slack      slackConfiguration
+	Discord    discordConfiguration
+	Teams      teamsConfiguration
+	Matrix     matrixConfiguration
+	Telegram   telegramConfiguration
+	Email      emailConfiguration
+	HTTP       httpConfiguration
+	Templates  templatesConfiguration
+	Digest     digestConfiguration
+	Outbox     outboxConfiguration
+	Scan       scanConfiguration
+	Directory  string `flagger.usage:"database directory"`
+	Store      string `flagger.usage:"database type (json or sqlite)"`
+	Milestones string `flagger.usage:"comma-separated list of star counts to notify as milestones: N, every:N or powers:N, e.g. 10,50,100,every:1000 (blank disables milestones)"`
+	Routes     string `flagger.usage:"YAML file with the rules that route notifications to notifiers (blank sends all notifications to all notifiers)"`
+	Owners     string `flagger.usage:"comma-separated list of users and organizations to scan for repositories"`
+	User       string `flagger.usage:"user to scan for repositories (deprecated: use -owners)"`
+	All        bool   `flagger.usage:"scan all repositories the GitHub token has access to"`
+	Archived   bool   `flagger.usage:"include archived repositories"`
+}
+
+// scope returns the repositories to scan.
+func (c configuration) scope() github.Scope {
+	return github.Scope{Owners: splitLists(c.Owners, c.User), All: c.All, IncludeArchived: c.Archived}
+}
+
+// splitLists returns the entries in the comma-separated lists, without duplicates.
+func splitLists(lists ...string) []string {
+	var entries []string
+	for _, entry := range strings.Split(strings.Join(lists, ","), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" && !slices.Contains(entries, entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// instance is a GitHub instance to scan, along with the webhook server that receives its events.
+type instance struct {
+	stars.Source
+	WebHook webhookConfiguration
+}
+
+// instances returns the GitHub instances to scan: the one configured by -github.* and, if -enterprise.github.server.url
+// is set, a GitHub Enterprise Server.
+func (c configuration) instances() ([]instance, error) {
+	client, err := newGitHubClient(c.GitHub, c.Directory)
+	if err != nil {
+		return nil, err
+	}
+	instances := []instance{{
+		Source:  stars.Source{Name: c.GitHub.Server.host(), Client: client, Scope: c.scope()},
+		WebHook: c.GitHub.WebHook,
+	}}
+	if c.Enterprise.GitHub.Server.URL == "" {
+		return instances, nil
+	}
+	if client, err = newGitHubClient(c.Enterprise.GitHub, c.Directory); err != nil {
+		return nil, fmt.Errorf("enterprise: %w", err)
+	}
+	return append(instances, instance{
+		Source: stars.Source{
+			Name:   c.Enterprise.GitHub.Server.host(),
+			Client: client,
+			Scope:  github.Scope{Owners: splitLists(c.Enterprise.Owners), All: c.Enterprise.All, IncludeArchived: c.Archived},
+		},
+		WebHook: c.Enterprise.GitHub.WebHook,
+	}), nil
+}
+
+// router returns the Router for the rules in c.Routes, with the notifiers it routes to. If the rules have no
+// default, notifications that match no rule go to all notifiers. A notifier called "slackbot:CHANNEL" is the
+// Slack bot, posting to CHANNEL instead of its default channel.
+func (c configuration) router(notifiers []stars.OutboxNotifier, slackBot *stars.SlackBotNotifier) (*stars.Router, []stars.OutboxNotifier, error) {
+	routes, err := stars.LoadRoutes(c.Routes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if routes.Default == nil {
+		for _, n := range notifiers {
+			routes.Default = append(routes.Default, n.Name)
+		}
+	}
+	router, err := stars.NewRouter(routes)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range router.Notifiers() {
+		if slices.ContainsFunc(notifiers, func(n stars.OutboxNotifier) bool { return n.Name == name }) {
+			continue
+		}
+		channel, ok := strings.CutPrefix(name, "slackbot:")
+		if !ok || channel == "" || slackBot == nil {
+			return nil, nil, fmt.Errorf("unknown notifier: %q", name)
+		}
+		notifiers = append(notifiers, stars.OutboxNotifier{Name: name, Notifier: slackBot.WithChannel(channel), MaxAttempts: c.Slack.Attempts})
+	}
+	return router, notifiers, nil
 }
 
 type githubConfiguration struct {
+	Server  serverConfiguration
 	Token   string `flagger.usage:"GitHub API token"`
+	API     string `flagger.usage:"GitHub API to use to scan repositories (rest or graphql)"`
+	Workers int    `flagger.usage:"number of repositories to scan in parallel (rest only)"`
+	App     appConfiguration
 	WebHook webhookConfiguration
 }
 
+type serverConfiguration struct {
+	URL        string `flagger.usage:"URL of the GitHub Enterprise Server REST API (e.g. https://github.example.com/api/v3/). Leave empty for github.com"`
+	UploadURL  string `flagger.usage:"URL of the GitHub Enterprise Server upload API (default: the REST API URL)"`
+	GraphQLURL string `flagger.usage:"URL of the GitHub Enterprise Server GraphQL API (default: /api/graphql on the REST API's host)"`
+	CABundle   string `flagger.usage:"path to a PEM file with additional CA certificates to trust"`
+}
+
+// host returns the hostname of the GitHub server.
+func (s serverConfiguration) host() string {
+	if u, err := url.Parse(s.URL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return stars.GitHubHost
+}
+
+// server returns the github.Server to connect to.
+func (s serverConfiguration) server() github.Server {
+	return github.Server{BaseURL: s.URL, UploadURL: s.UploadURL, GraphQLURL: s.GraphQLURL, CABundle: s.CABundle}
+}
+
+// cacheFilename returns the name of the file holding the cached responses of the GitHub server.
+func (s serverConfiguration) cacheFilename() string {
+	if s.URL == "" {
+		return githubCacheFilename
+	}
+	return "github-cache-" + s.host() + ".json"
+}
+
+type enterpriseConfiguration struct {
+	GitHub githubConfiguration
+	Owners string `flagger.usage:"comma-separated list of users and organizations on the GitHub Enterprise Server to scan for repositories"`
+	All    bool   `flagger.usage:"scan all repositories on the GitHub Enterprise Server that its token has access to"`
+}
+
+type appConfiguration struct {
+	ID         int    `flagger.usage:"GitHub App ID. If set, authenticate as the GitHub App rather than with github.token"`
+	PrivateKey string `flagger.usage:"path to the GitHub App's private key (PEM format)"`
+}
+
 type webhookConfiguration struct {
 	Addr   string `flagger.usage:"address to listen on for GitHub webhook calls"`
 	Secret string `flagger.usage:"secret to verify GitHub webhook calls"`
 }
 
 type slackConfiguration struct {
-	Webhook string `flagger.usage:"Slack webhook URL to post messages to"`
+	Webhook  string `flagger.usage:"Slack webhook URL to post messages to"`
+	Attempts int    `flagger.usage:"maximum number of attempts to deliver a Slack message"`
+	Bot      slackBotConfiguration
+}
+
+type slackBotConfiguration struct {
+	Token   string `flagger.usage:"token of the Slack app's bot user to post messages as (xoxb-...)"`
+	Channel string `flagger.usage:"name or ID of the Slack channel for the bot user to post messages to"`
+	Secret  string `flagger.usage:"signing secret of the Slack app, to verify /stars slash commands (blank disables /stars)"`
+}
+
+type discordConfiguration struct {
+	Webhook  string `flagger.usage:"Discord webhook URL to post messages to"`
+	Attempts int    `flagger.usage:"maximum number of attempts to deliver a Discord message"`
+}
+
+type teamsConfiguration struct {
+	Webhook  string `flagger.usage:"Microsoft Teams incoming webhook or Workflows URL to post messages to"`
+	Attempts int    `flagger.usage:"maximum number of attempts to deliver a Teams message"`
+}
+
+type matrixConfiguration struct {
+	HomeServer string `flagger.usage:"URL of the Matrix homeserver to post messages to"`
+	Token      string `flagger.usage:"access token of the Matrix user to post messages as"`
+	Room       string `flagger.usage:"ID of the Matrix room to post messages to"`
+	Attempts   int    `flagger.usage:"maximum number of attempts to deliver a Matrix message"`
+}
+
+type telegramConfiguration struct {
+	Token    string `flagger.usage:"token of the Telegram bot to post messages as"`
+	Chat     string `flagger.usage:"ID of the Telegram chat to post messages to"`
+	Format   string `flagger.usage:"format of the Telegram messages (MarkdownV2 or HTML)"`
+	Attempts int    `flagger.usage:"maximum number of attempts to deliver a Telegram message"`
+}
+
+type emailConfiguration struct {
+	Host     string        `flagger.usage:"SMTP server to send emails with"`
+	Port     int           `flagger.usage:"SMTP server port (default depends on -email.security)"`
+	Security string        `flagger.usage:"SMTP connection security (starttls, tls or none)"`
+	Username string        `flagger.usage:"SMTP username (blank disables authentication)"`
+	Password string        `flagger.usage:"SMTP password"`
+	From     string        `flagger.usage:"sender's email address"`
+	To       string        `flagger.usage:"comma-separated list of recipients' email addresses"`
+	Digest   time.Duration `flagger.usage:"time between two email digests (0 sends an email for every change)"`
+	Attempts int           `flagger.usage:"maximum number of attempts to deliver an email"`
+}
+
+type httpConfiguration struct {
+	URL      string `flagger.usage:"URL to send HTTP notifications to"`
+	Method   string `flagger.usage:"HTTP method of the notifications"`
+	Headers  string `flagger.usage:"comma-separated list of name=value headers to add to the notifications"`
+	Template string `flagger.usage:"file with the text/template of the notifications' body (default: JSON)"`
+	Secret   string `flagger.usage:"secret to sign the notifications' body with (blank disables signing)"`
+	Attempts int    `flagger.usage:"maximum number of attempts to deliver an HTTP notification"`
+}
+
+// notifier returns an HTTPNotifier for the configuration.
+func (c httpConfiguration) notifier() (stars.HTTPNotifier, error) {
+	n := stars.HTTPNotifier{URL: c.URL, Method: c.Method, Secret: c.Secret}
+	for _, header := range splitLists(c.Headers) {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok {
+			return stars.HTTPNotifier{}, fmt.Errorf("invalid header: %q", header)
+		}
+		if n.Headers == nil {
+			n.Headers = make(map[string]string)
+		}
+		n.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	if c.Template != "" {
+		text, err := os.ReadFile(c.Template)
+		if err != nil {
+			return stars.HTTPNotifier{}, fmt.Errorf("template: %w", err)
+		}
+		if n.Template, err = stars.NewHTTPTemplate(string(text)); err != nil {
+			return stars.HTTPNotifier{}, fmt.Errorf("template: %w", err)
+		}
+	}
+	return n, nil
+}
+
+type templatesConfiguration struct {
+	File      string `flagger.usage:"file with the text/templates of the notifications' messages"`
+	Added     string `flagger.usage:"text/template of the message when a repository receives a star"`
+	Removed   string `flagger.usage:"text/template of the message when a repository loses a star"`
+	Milestone string `flagger.usage:"text/template of the message when a repository reaches a milestone"`
+}
+
+// templates returns the Templates for the configuration. Templates set with Added, Removed and Milestone override
+// the ones in File. If no templates are configured, templates returns nil, so notifiers use the default messages.
+func (c templatesConfiguration) templates() (*stars.Templates, error) {
+	var texts []string
+	if c.File != "" {
+		content, err := os.ReadFile(c.File)
+		if err != nil {
+			return nil, err
+		}
+		texts = append(texts, string(content))
+	}
+	var flags strings.Builder
+	for _, tmpl := range []struct {
+		kind stars.MessageKind
+		text string
+	}{
+		{kind: stars.MessageAdded, text: c.Added},
+		{kind: stars.MessageRemoved, text: c.Removed},
+		{kind: stars.MessageMilestone, text: c.Milestone},
+	} {
+		if tmpl.text != "" {
+			flags.WriteString(`{{ define "` + string(tmpl.kind) + `" }}` + tmpl.text + `{{ end }}`)
+		}
+	}
+	if flags.Len() > 0 {
+		texts = append(texts, flags.String())
+	}
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	return stars.NewTemplates(texts...)
+}
+
+type digestConfiguration struct {
+	Schedule string `flagger.usage:"cron schedule of the digests of the stars gained and lost since the previous digest, e.g. 0 9 * * MON (blank disables digests)"`
+	Timezone string `flagger.usage:"time zone of the digest schedule, e.g. Europe/Brussels"`
+}
+
+// scheduler returns a DigestScheduler that sends its digests with the notifier. The scheduler's state is kept in directory.
+func (c digestConfiguration) scheduler(store stars.Store, notifier stars.DigestNotifier, directory string) (*stars.DigestScheduler, error) {
+	schedule, err := stars.ParseSchedule(c.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("timezone: %w", err)
+	}
+	scheduler, err := stars.NewDigestScheduler(store, notifier, schedule, filepath.Join(directory, stars.DigestFilename))
+	if err != nil {
+		return nil, err
+	}
+	scheduler.Location = location
+	return scheduler, nil
+}
+
+type outboxConfiguration struct {
+	Backoff    time.Duration `flagger.usage:"time to wait before retrying a failed notification for the first time. Doubles after each attempt"`
+	MaxBackoff time.Duration `flagger.usage:"maximum time between two attempts to deliver a notification"`
+}
+
+type scanConfiguration struct {
+	Interval    time.Duration `flagger.usage:"time between two scans of all repositories (0 disables periodic scans)"`
+	Jitter      time.Duration `flagger.usage:"maximum random time added to, or subtracted from, the scan interval"`
+	MinInterval time.Duration `flagger.usage:"minimum time between two scans"`
 }
 
 func main() {
@@ -51,10 +350,33 @@ func main() {
 		Log:  flagger.DefaultLog,
 		Prom: flagger.DefaultProm,
 		GitHub: githubConfiguration{
+			API:     "rest",
+			Workers: 4,
 			WebHook: webhookConfiguration{Addr: ":8080"},
 		},
-		Slack:     slackConfiguration{},
+		Enterprise: enterpriseConfiguration{
+			GitHub: githubConfiguration{
+				API:     "rest",
+				Workers: 4,
+				WebHook: webhookConfiguration{Addr: ":8081"},
+			},
+		},
+		Slack:    slackConfiguration{Attempts: notifyAttempts},
+		Discord:  discordConfiguration{Attempts: notifyAttempts},
+		Teams:    teamsConfiguration{Attempts: notifyAttempts},
+		Matrix:   matrixConfiguration{Attempts: notifyAttempts},
+		Telegram: telegramConfiguration{Format: string(stars.TelegramMarkdownV2), Attempts: notifyAttempts},
+		Email:    emailConfiguration{Security: string(stars.EmailSecuritySTARTTLS), Attempts: notifyAttempts},
+		HTTP:     httpConfiguration{Method: http.MethodPost, Attempts: notifyAttempts},
+		Outbox:   outboxConfiguration{Backoff: 10 * time.Second, MaxBackoff: 30 * time.Minute},
+		Digest:   digestConfiguration{Timezone: "UTC"},
+		Scan: scanConfiguration{
+			Interval:    time.Hour,
+			Jitter:      5 * time.Minute,
+			MinInterval: time.Minute,
+		},
 		Directory: ".",
+		Store:     "json",
 	}
 	flagger.SetFlags(flag.CommandLine, &cfg)
 	flag.Parse()
@@ -62,42 +384,235 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	client := github.NewGitHubClient(cfg.GitHub.Token)
-	if err := runWithClient(ctx, client, cfg); err != nil {
+	instances, err := cfg.instances()
+	if err != nil {
+		cfg.Logger(os.Stderr, nil).Error("failed to create GitHub client", "err", err)
+		os.Exit(1)
+	}
+	if err = run(ctx, instances, cfg, prometheus.DefaultRegisterer); err != nil {
 		cfg.Logger(os.Stderr, nil).Error("failed to run", "err", err)
 		os.Exit(1)
 	}
 }
 
-func runWithClient(ctx context.Context, client stars.Client, cfg configuration) error {
+// newGitHubClient returns a client that authenticates either as a GitHub App, or with a GitHub API token.
+// The client caches GitHub API responses in the database directory.
+func newGitHubClient(cfg githubConfiguration, directory string) (stars.Client, error) {
+	cachePath := filepath.Join(directory, cfg.Server.cacheFilename())
+	cache, err := github.NewCache(cachePath)
+	if err != nil {
+		// the cache only saves API calls. No need to fail if it's corrupt.
+		_ = os.Remove(cachePath)
+		if cache, err = github.NewCache(cachePath); err != nil {
+			return nil, fmt.Errorf("failed to load GitHub cache: %w", err)
+		}
+	}
+	if cfg.App.ID == 0 {
+		switch cfg.API {
+		case "rest", "":
+			client, err := github.NewGitHubClient(cfg.Server.server(), cfg.Token, cache)
+			if err != nil {
+				return nil, err
+			}
+			client.Workers = cfg.Workers
+			return client, nil
+		case "graphql":
+			return github.NewGitHubGraphQLClient(cfg.Server.server(), cfg.Token, cache)
+		default:
+			return nil, fmt.Errorf("invalid GitHub API: %q", cfg.API)
+		}
+	}
+	if cfg.API == "graphql" {
+		return nil, errors.New("the graphql API is not supported for GitHub Apps")
+	}
+	privateKey, err := os.ReadFile(cfg.App.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	client, err := github.NewGitHubAppClient(cfg.Server.server(), int64(cfg.App.ID), privateKey, cache)
+	if err != nil {
+		return nil, err
+	}
+	client.Workers = cfg.Workers
+	return client, nil
+}
+
+// newStore opens the database of the requested type in the database directory.
+func newStore(storeType string, directory string) (stars.Store, error) {
+	switch storeType {
+	case "json", "":
+		return stars.NewJSONStore(directory)
+	case "sqlite":
+		return stars.NewSQLiteStore(directory)
+	default:
+		return nil, fmt.Errorf("invalid database type: %q", storeType)
+	}
+}
+
+func run(ctx context.Context, instances []instance, cfg configuration, r prometheus.Registerer) error {
 	// setup
 	logger := cfg.Logger(os.Stderr, nil)
 	logger.Info("starting github-stars", "version", version)
 	ctx = slogctx.NewWithContext(ctx, logger)
 
-	notifiers := stars.Notifiers{stars.SlogNotifier{}}
-	if cfg.Slack.Webhook != "" {
-		notifiers = append(notifiers, stars.SlackNotifier{WebHookURL: cfg.Slack.Webhook})
+	db, err := newStore(cfg.Store, cfg.Directory)
+	if err != nil {
+		return fmt.Errorf("failed to load database: %w", err)
+	}
+	if s, ok := db.(*stars.JSONStore); ok && s.RecoveredFrom() != "" {
+		logger.Warn("database was corrupt. restored from backup", "backup", s.RecoveredFrom())
 	}
+	defer func() { _ = db.Close() }()
 
-	store, err := stars.NewNotifyingStore(cfg.Directory, notifiers)
-	var jsonErr *json.UnmarshalTypeError
-	if errors.As(err, &jsonErr) {
-		logger.Warn("failed to load database. reinitializing ....", "err", err)
-		_ = os.Remove(filepath.Join(cfg.Directory, stars.StoreFilename))
-		store, err = stars.NewNotifyingStore(cfg.Directory, notifiers)
+	// all notifiers, except for logging, are delivered in the background, so a slow or unavailable service doesn't
+	// slow down webhook calls. Undelivered notifications are kept in the outbox, so they survive a restart.
+	templates, err := cfg.Templates.templates()
+	if err != nil {
+		return fmt.Errorf("templates: %w", err)
+	}
+	var notifiers []stars.OutboxNotifier
+	if cfg.Slack.Webhook != "" {
+		notifiers = append(notifiers, stars.OutboxNotifier{
+			Name:        "slack",
+			Notifier:    stars.SlackNotifier{WebHookURL: cfg.Slack.Webhook, Templates: templates},
+			MaxAttempts: cfg.Slack.Attempts,
+		})
+	}
+	var slackBot *stars.SlackBotNotifier
+	if cfg.Slack.Bot.Token != "" {
+		if slackBot, err = stars.NewSlackBotNotifier(cfg.Slack.Bot.Token, cfg.Slack.Bot.Channel, filepath.Join(cfg.Directory, stars.SlackThreadsFilename)); err != nil {
+			return fmt.Errorf("slack bot: %w", err)
+		}
+		slackBot.Store = db
+		slackBot.Templates = templates
+		notifiers = append(notifiers, stars.OutboxNotifier{Name: "slackbot", Notifier: slackBot, MaxAttempts: cfg.Slack.Attempts})
+	}
+	if cfg.Discord.Webhook != "" {
+		notifiers = append(notifiers, stars.OutboxNotifier{
+			Name:        "discord",
+			Notifier:    stars.DiscordNotifier{WebHookURL: cfg.Discord.Webhook, Store: db, Templates: templates},
+			MaxAttempts: cfg.Discord.Attempts,
+		})
+	}
+	if cfg.Teams.Webhook != "" {
+		notifiers = append(notifiers, stars.OutboxNotifier{
+			Name:        "teams",
+			Notifier:    stars.TeamsNotifier{WebHookURL: cfg.Teams.Webhook, Templates: templates},
+			MaxAttempts: cfg.Teams.Attempts,
+		})
+	}
+	if cfg.Matrix.HomeServer != "" {
+		notifiers = append(notifiers, stars.OutboxNotifier{
+			Name: "matrix",
+			Notifier: stars.MatrixNotifier{
+				HomeServerURL: cfg.Matrix.HomeServer,
+				AccessToken:   cfg.Matrix.Token,
+				RoomID:        cfg.Matrix.Room,
+				Templates:     templates,
+			},
+			MaxAttempts: cfg.Matrix.Attempts,
+		})
+	}
+	if cfg.Telegram.Token != "" {
+		notifiers = append(notifiers, stars.OutboxNotifier{
+			Name: "telegram",
+			Notifier: stars.TelegramNotifier{
+				BotToken:  cfg.Telegram.Token,
+				ChatID:    cfg.Telegram.Chat,
+				ParseMode: stars.TelegramParseMode(cfg.Telegram.Format),
+				Templates: templates,
+			},
+			MaxAttempts: cfg.Telegram.Attempts,
+		})
+	}
+	if cfg.HTTP.URL != "" {
+		n, err := cfg.HTTP.notifier()
+		if err != nil {
+			return fmt.Errorf("http notifier: %w", err)
+		}
+		notifiers = append(notifiers, stars.OutboxNotifier{Name: "http", Notifier: n, MaxAttempts: cfg.HTTP.Attempts})
+	}
+	if cfg.Email.Host != "" {
+		email := stars.EmailNotifier{
+			Host:           cfg.Email.Host,
+			Port:           cfg.Email.Port,
+			Security:       stars.EmailSecurity(cfg.Email.Security),
+			Username:       cfg.Email.Username,
+			Password:       cfg.Email.Password,
+			From:           cfg.Email.From,
+			To:             splitLists(cfg.Email.To),
+			DigestInterval: cfg.Email.Digest,
+			DigestPath:     filepath.Join(cfg.Directory, stars.EmailDigestFilename),
+			Templates:      templates,
+		}
+		notifiers = append(notifiers, stars.OutboxNotifier{Name: "email", Notifier: &email, MaxAttempts: cfg.Email.Attempts})
+		// on shutdown, wait for the last digest to be sent
+		digestCtx, cancelDigest := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Go(func() { email.Run(digestCtx) })
+		defer wg.Wait()
+		defer cancelDigest()
 	}
+	var router *stars.Router
+	if cfg.Routes != "" {
+		if router, notifiers, err = cfg.router(notifiers, slackBot); err != nil {
+			return fmt.Errorf("routes: %w", err)
+		}
+	}
+	outbox, err := stars.NewOutbox(filepath.Join(cfg.Directory, stars.OutboxFilename), notifiers...)
 	if err != nil {
-		return fmt.Errorf("failed to load database: %w", err)
+		return fmt.Errorf("failed to load outbox: %w", err)
+	}
+	outbox.Router = router
+	outbox.MinBackoff = cfg.Outbox.Backoff
+	outbox.MaxBackoff = cfg.Outbox.MaxBackoff
+	if pending := outbox.Len(); pending > 0 {
+		logger.Info("delivering notifications from previous run", "pending", pending)
+	}
+	go outbox.Run(ctx)
+	store := stars.NewNotifyingStore(db, stars.Notifiers{stars.SlogNotifier{}, outbox})
+	if cfg.Milestones != "" {
+		milestones, err := stars.ParseMilestones(cfg.Milestones)
+		if err != nil {
+			return fmt.Errorf("milestones: %w", err)
+		}
+		if store.Milestones, err = stars.NewMilestoneTracker(milestones, filepath.Join(cfg.Directory, stars.MilestonesFilename)); err != nil {
+			return fmt.Errorf("milestones: %w", err)
+		}
+	}
+	if cfg.Digest.Schedule != "" {
+		scheduler, err := cfg.Digest.scheduler(db, outbox, cfg.Directory)
+		if err != nil {
+			return fmt.Errorf("digest: %w", err)
+		}
+		go scheduler.Run(ctx)
 	}
 
 	// on startup, scan all repos. This will find any stars while we weren't running.
-	start := time.Now()
-	logger.Info("starting scan")
-	if err = stars.Scan(ctx, cfg.User, client, store, cfg.Archived); err != nil {
-		return fmt.Errorf("failed to scan: %w", err)
+	sources := make([]stars.Source, len(instances))
+	for i, inst := range instances {
+		sources[i] = inst.Source
+	}
+	reconciler := stars.Reconciler{
+		Store:       store,
+		Sources:     sources,
+		Interval:    cfg.Scan.Interval,
+		Jitter:      cfg.Scan.Jitter,
+		MinInterval: cfg.Scan.MinInterval,
+	}
+	// if the scan fails, we still handle webhook calls. The next periodic scan will pick up anything we missed.
+	if err := reconciler.Scan(ctx); err != nil {
+		logger.Warn("initial scan failed. will retry at the next scan", "err", err)
+	}
+	r.MustRegister(&reconciler)
+	for _, inst := range instances {
+		if c, ok := inst.Client.(prometheus.Collector); ok {
+			prometheus.WrapRegistererWith(prometheus.Labels{"host": inst.Name}, r).MustRegister(c)
+		}
 	}
-	logger.Info("scan complete", "duration_msec", time.Since(start).Milliseconds())
+
+	// periodically rescan all repos. This will find any stars for which we didn't receive a webhook call.
+	go reconciler.Run(ctx)
 
 	// start the Prometheus metrics server
 	go func() {
@@ -106,19 +621,36 @@ func runWithClient(ctx context.Context, client stars.Client, cfg configuration)
 		}
 	}()
 
-	// start the GitHub webhook handler
-	s := http.Server{
-		Addr: cfg.GitHub.WebHook.Addr,
-		Handler: github.WebhookHandler(
-			github.WebhookHandlers{StarEvent: stars.Handler(store)},
-			cfg.GitHub.WebHook.Secret,
-			logger,
-		),
+	// start a GitHub webhook handler for each instance. If one fails, stop all of them.
+	webhookCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(instances))
+	for i, inst := range instances {
+		var h http.Handler = github.WebhookHandler(
+			github.WebhookHandlers{StarEvent: stars.Handler(store, inst.Name)},
+			inst.WebHook.Secret,
+			logger.With("host", inst.Name),
+		)
+		// the Slack slash command is served by the first webhook server
+		if i == 0 && cfg.Slack.Bot.Secret != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/", h)
+			mux.Handle("POST /slack/commands", stars.SlackCommandHandler(db, cfg.Slack.Bot.Secret, logger))
+			h = mux
+		}
+		s := http.Server{Addr: inst.WebHook.Addr, Handler: h}
+		logger.Info("starting webhook server", "host", inst.Name, "addr", inst.WebHook.Addr)
+		go func() {
+			err := httputils.RunServer(webhookCtx, &s)
+			if err != nil {
+				err = fmt.Errorf("failed to start webhook server for %s: %w", inst.Name, err)
+				cancel()
+			}
+			errs <- err
+		}()
 	}
-
-	logger.Info("starting webhook server", "addr", cfg.GitHub.WebHook.Addr)
-	if err = httputils.RunServer(ctx, &s); err != nil {
-		return fmt.Errorf("failed to start webhook server: %w", err)
+	for range instances {
+		err = errors.Join(err, <-errs)
 	}
-	return nil
+	return err
 }
@@ -2,42 +2,59 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/clambin/github-stars/internal/github"
 	"github.com/clambin/github-stars/internal/stars"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestRun(t *testing.T) {
-	client := fakeClient{stargazers: []github.Stargazer{
-		{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "user1/foo", Login: "user1"},
-		{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "user1/foo", Login: "user2"},
-	}}
-	cfg := configuration{
-		User:      "user1",
-		Directory: t.TempDir(),
-		GitHub:    githubConfiguration{WebHook: webhookConfiguration{Addr: ":8080"}},
+	instances := []instance{
+		{
+			Source: stars.Source{Name: "github.com", Client: fakeClient{stargazers: []github.Stargazer{
+				{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "user1/foo", Login: "user1"},
+				{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "user1/foo", Login: "user2"},
+			}}},
+			WebHook: webhookConfiguration{Addr: ":8080"},
+		},
+		{
+			Source: stars.Source{Name: "github.example.com", Client: fakeClient{stargazers: []github.Stargazer{
+				{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "org1/bar", Login: "user3"},
+			}}},
+			WebHook: webhookConfiguration{Addr: ":8081"},
+		},
 	}
+	cfg := configuration{Directory: t.TempDir()}
 
 	// start the handler
 	ctx, cancel := context.WithCancel(context.Background())
 	t.Cleanup(cancel)
 	errCh := make(chan error)
 	go func() {
-		errCh <- runWithClient(ctx, &client, cfg)
+		errCh <- run(ctx, instances, cfg, prometheus.NewRegistry())
 	}()
 
-	// wait for the handler to perform the scan and start serving the webhook
-	for {
-		time.Sleep(10 * time.Millisecond)
-		if resp, err := http.Get(fmt.Sprintf("http://localhost%s/readyz", cfg.GitHub.WebHook.Addr)); err == nil {
-			_ = resp.Body.Close()
-			if resp.StatusCode == http.StatusOK {
-				break
+	// wait for the handler to perform the scan and start serving the webhooks
+	for _, inst := range instances {
+		for {
+			time.Sleep(10 * time.Millisecond)
+			if resp, err := http.Get(fmt.Sprintf("http://localhost%s/readyz", inst.WebHook.Addr)); err == nil {
+				_ = resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					break
+				}
 			}
 		}
 	}
@@ -47,12 +64,156 @@ func TestRun(t *testing.T) {
 	require.NoError(t, <-errCh)
 }
 
+func TestConfiguration_Scope(t *testing.T) {
+	cfg := configuration{Owners: "user1, org1,,user2", User: "user1", All: true, Archived: true}
+	want := github.Scope{Owners: []string{"user1", "org1", "user2"}, All: true, IncludeArchived: true}
+	assert.Equal(t, want, cfg.scope())
+}
+
+func TestConfiguration_Instances(t *testing.T) {
+	cfg := configuration{
+		Owners:    "user1",
+		Directory: t.TempDir(),
+		Archived:  true,
+		GitHub:    githubConfiguration{Token: "token"},
+	}
+	instances, err := cfg.instances()
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, "github.com", instances[0].Name)
+
+	cfg.Enterprise = enterpriseConfiguration{
+		GitHub: githubConfiguration{Server: serverConfiguration{URL: "https://github.example.com/api/v3/"}, Token: "token"},
+		Owners: "org1",
+	}
+	instances, err = cfg.instances()
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	assert.Equal(t, "github.example.com", instances[1].Name)
+	assert.Equal(t, github.Scope{Owners: []string{"org1"}, IncludeArchived: true}, instances[1].Scope)
+	assert.Equal(t, "github-cache-github.example.com.json", cfg.Enterprise.GitHub.Server.cacheFilename())
+
+	cfg.Enterprise.GitHub.Server.CABundle = filepath.Join(t.TempDir(), "missing.pem")
+	_, err = cfg.instances()
+	assert.Error(t, err)
+}
+
+func TestNewStore(t *testing.T) {
+	for _, storeType := range []string{"json", "sqlite"} {
+		store, err := newStore(storeType, t.TempDir())
+		require.NoError(t, err)
+		assert.NoError(t, store.Close())
+	}
+	_, err := newStore("csv", t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestNewGitHubClient(t *testing.T) {
+	client, err := newGitHubClient(githubConfiguration{Token: "token"}, t.TempDir())
+	require.NoError(t, err)
+	assert.IsType(t, &github.Client{}, client)
+
+	client, err = newGitHubClient(githubConfiguration{Token: "token", API: "graphql"}, t.TempDir())
+	require.NoError(t, err)
+	assert.IsType(t, &github.GraphQLClient{}, client)
+
+	_, err = newGitHubClient(githubConfiguration{Token: "token", API: "soap"}, t.TempDir())
+	assert.Error(t, err)
+
+	_, err = newGitHubClient(githubConfiguration{App: appConfiguration{ID: 1, PrivateKey: filepath.Join(t.TempDir(), "missing.pem")}}, t.TempDir())
+	assert.Error(t, err)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+	client, err = newGitHubClient(githubConfiguration{App: appConfiguration{ID: 1, PrivateKey: keyPath}}, t.TempDir())
+	require.NoError(t, err)
+	assert.IsType(t, &github.AppClient{}, client)
+
+	// a corrupt cache is discarded
+	directory := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(directory, githubCacheFilename), []byte("invalid"), 0644))
+	_, err = newGitHubClient(githubConfiguration{Token: "token"}, directory)
+	require.NoError(t, err)
+}
+
 var _ stars.Client = fakeClient{}
 
 type fakeClient struct {
 	stargazers []github.Stargazer
 }
 
-func (f fakeClient) Stargazers(context.Context, string, bool) ([]github.Stargazer, error) {
+func (f fakeClient) Stargazers(context.Context, github.Scope) ([]github.Stargazer, error) {
 	return f.stargazers, nil
 }
+
+func TestTemplatesConfiguration_Templates(t *testing.T) {
+	tmpl, err := templatesConfiguration{}.templates()
+	require.NoError(t, err)
+	assert.Nil(t, tmpl)
+
+	path := filepath.Join(t.TempDir(), "templates.txt")
+	require.NoError(t, os.WriteFile(path, []byte(`{{ define "added" }}{{ .Repo }}{{ end }}`), 0600))
+	tmpl, err = templatesConfiguration{File: path, Added: "{{ .Repo }}!", Removed: "{{ .Repo }}?"}.templates()
+	require.NoError(t, err)
+	assert.NotNil(t, tmpl)
+
+	_, err = templatesConfiguration{File: filepath.Join(t.TempDir(), "missing.txt")}.templates()
+	assert.Error(t, err)
+	_, err = templatesConfiguration{Added: "{{ .Repo"}.templates()
+	assert.Error(t, err)
+}
+
+func TestHTTPConfiguration_Notifier(t *testing.T) {
+	templatePath := filepath.Join(t.TempDir(), "template.txt")
+	require.NoError(t, os.WriteFile(templatePath, []byte(`{{ .Repo }}`), 0600))
+
+	n, err := httpConfiguration{URL: "http://localhost", Headers: "Title=GitHub stars, Priority = high", Template: templatePath}.notifier()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Title": "GitHub stars", "Priority": "high"}, n.Headers)
+	assert.NotNil(t, n.Template)
+
+	_, err = httpConfiguration{Headers: "Title"}.notifier()
+	assert.Error(t, err)
+	_, err = httpConfiguration{Template: filepath.Join(t.TempDir(), "missing.txt")}.notifier()
+	assert.Error(t, err)
+}
+
+func TestConfiguration_Router(t *testing.T) {
+	slackBot, err := stars.NewSlackBotNotifier("token", "#stars", filepath.Join(t.TempDir(), stars.SlackThreadsFilename))
+	require.NoError(t, err)
+	notifiers := []stars.OutboxNotifier{{Name: "slackbot", Notifier: slackBot}, {Name: "email", Notifier: stars.SlogNotifier{}}}
+
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("rules:\n  - repo: org/infra-*\n    notifiers: [slackbot:#infra]\n"), 0600))
+	router, got, err := configuration{Routes: path}.router(notifiers, slackBot)
+	require.NoError(t, err)
+	names := make([]string, len(got))
+	for i, n := range got {
+		names[i] = n.Name
+	}
+	assert.Equal(t, []string{"slackbot", "email", "slackbot:#infra"}, names)
+	// no default in the routes: other repositories go to all configured notifiers
+	assert.Equal(t, []string{"slackbot", "email"}, router.Route(stars.MessageAdded, github.Stargazer{RepoName: "org/web"}))
+
+	_, _, err = configuration{Routes: path}.router(notifiers[1:], nil)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("default: [discord]\n"), 0600))
+	_, _, err = configuration{Routes: path}.router(notifiers, slackBot)
+	assert.Error(t, err)
+}
+
+func TestDigestConfiguration_Scheduler(t *testing.T) {
+	dir := t.TempDir()
+	scheduler, err := digestConfiguration{Schedule: "0 9 * * MON", Timezone: "Europe/Brussels"}.scheduler(nil, nil, dir)
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Brussels", scheduler.Location.String())
+	assert.FileExists(t, filepath.Join(dir, stars.DigestFilename))
+
+	_, err = digestConfiguration{Schedule: "0 9 * *", Timezone: "UTC"}.scheduler(nil, nil, dir)
+	assert.Error(t, err)
+	_, err = digestConfiguration{Schedule: "0 9 * * MON", Timezone: "Mars/Olympus_Mons"}.scheduler(nil, nil, dir)
+	assert.Error(t, err)
+}
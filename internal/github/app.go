@@ -0,0 +1,295 @@
+package github
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v78/github"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AppClient authenticates as a GitHub App and scans the repositories of the App's installations.
+// Each installation is accessed with its own installation access token, which is refreshed before it expires.
+//
+// All installations share the same RateLimiter: if one installation hits a rate limit, all installations wait.
+type AppClient struct {
+	Apps
+	RateLimiter *RateLimiter
+	Cache       *Cache
+	// Workers is the number of repositories whose stargazers are retrieved in parallel. Default is 1.
+	Workers   int
+	transport http.RoundTripper
+	server    Server
+	clients   map[int64]*Client
+	lock      sync.Mutex
+}
+
+// Apps is the part of the GitHub Apps API that requires the App to authenticate with a JWT.
+type Apps interface {
+	ListInstallations(ctx context.Context, opts *github.ListOptions) ([]*github.Installation, *github.Response, error)
+	CreateInstallationToken(ctx context.Context, id int64, opts *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error)
+}
+
+// NewGitHubAppClient returns an AppClient for the GitHub App with the provided ID and private key (in PEM format).
+// If cache is not nil, the AppClient uses it to avoid retrieving unchanged data from GitHub.
+func NewGitHubAppClient(server Server, appID int64, privateKey []byte, cache *Cache) (*AppClient, error) {
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("private key: %w", err)
+	}
+	transport, err := server.transport()
+	if err != nil {
+		return nil, err
+	}
+	client, err := server.client(&appTransport{
+		appID: appID,
+		key:   key,
+		next:  transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &AppClient{
+		Apps:        client.Apps,
+		RateLimiter: &RateLimiter{},
+		Cache:       cache,
+		server:      server,
+		transport:   transport,
+		clients:     make(map[int64]*Client),
+	}, nil
+}
+
+var _ prometheus.Collector = (*AppClient)(nil)
+
+// Describe implements prometheus.Collector.
+func (a *AppClient) Describe(ch chan<- *prometheus.Desc) {
+	a.RateLimiter.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (a *AppClient) Collect(ch chan<- prometheus.Metric) {
+	a.RateLimiter.Collect(ch)
+}
+
+// Stargazers returns the stargazers for the repositories of all installations in scope.
+// An installation is in scope if scope.All is set, or if the installation's account is one of scope.Owners.
+// Stargazers returns an error if any of scope.Owners has no installation.
+func (a *AppClient) Stargazers(ctx context.Context, scope Scope) ([]Stargazer, error) {
+	listOptions := github.ListOptions{PerPage: recordsPerPage}
+	installations, err := paginate(ctx, a.RateLimiter, &listOptions, func() ([]*github.Installation, *github.Response, error) {
+		return a.ListInstallations(ctx, &listOptions)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("installations: %w", err)
+	}
+	if !scope.All {
+		// a missing installation would look as if all stars of the owner's repositories were removed
+		var missing []string
+		for _, owner := range scope.Owners {
+			if !slices.ContainsFunc(installations, func(installation *github.Installation) bool { return isOwner(installation, owner) }) {
+				missing = append(missing, owner)
+			}
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("no installation for %s", strings.Join(missing, ", "))
+		}
+	}
+
+	var stargazers []Stargazer
+	seen := make(map[string]struct{})
+	for _, installation := range installations {
+		if !scope.All && !slices.ContainsFunc(scope.Owners, func(owner string) bool { return isOwner(installation, owner) }) {
+			continue
+		}
+		client, err := a.installationClient(installation.GetID())
+		if err != nil {
+			return nil, fmt.Errorf("installation %d: %w", installation.GetID(), err)
+		}
+		gazers, err := client.Stargazers(ctx, Scope{All: true, IncludeArchived: scope.IncludeArchived})
+		if err != nil {
+			return nil, fmt.Errorf("installation %d: %w", installation.GetID(), err)
+		}
+		// a repository may be part of several installations
+		for _, gazer := range gazers {
+			if _, ok := seen[gazer.RepoName+"/"+gazer.Login]; !ok {
+				seen[gazer.RepoName+"/"+gazer.Login] = struct{}{}
+				stargazers = append(stargazers, gazer)
+			}
+		}
+	}
+	return stargazers, nil
+}
+
+// isOwner returns true if the installation belongs to owner. Like GitHub logins, owners are case-insensitive.
+func isOwner(installation *github.Installation, owner string) bool {
+	return strings.EqualFold(installation.GetAccount().GetLogin(), owner)
+}
+
+// installationClient returns a Client that authenticates as the installation.
+// The Client is reused for subsequent calls, so its installation access token is only refreshed when it's about to expire.
+func (a *AppClient) installationClient(installationID int64) (*Client, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if client, ok := a.clients[installationID]; ok {
+		return client, nil
+	}
+	client, err := a.server.client(a.Cache.transport(&installationTransport{
+		installationID: installationID,
+		apps:           a.Apps,
+		next:           cmp.Or[http.RoundTripper](a.transport, http.DefaultTransport),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	a.clients[installationID] = &Client{
+		Repositories: client.Repositories,
+		Activity:     client.Activity,
+		Users:        client.Users,
+		Installation: client.Apps,
+		RateLimiter:  a.RateLimiter,
+		Cache:        a.Cache,
+		Workers:      a.Workers,
+	}
+	return a.clients[installationID], nil
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	// jwtLifetime is the lifetime of an App's JWT. GitHub allows a maximum of 10 minutes.
+	jwtLifetime = 9 * time.Minute
+	// jwtClockDrift allows for the GitHub server's clock being behind ours.
+	jwtClockDrift = time.Minute
+	// tokenRefreshMargin is how long before it expires that we refresh a token.
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// appTransport authenticates a request as a GitHub App, using a JWT signed with the App's private key.
+type appTransport struct {
+	next    http.RoundTripper
+	key     *rsa.PrivateKey
+	expires time.Time
+	jwt     string
+	appID   int64
+	lock    sync.Mutex
+}
+
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.token()
+	if err != nil {
+		return nil, fmt.Errorf("jwt: %w", err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(req)
+}
+
+// token returns a JWT for the App, creating a new one if the current one is about to expire.
+func (t *appTransport) token() (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.jwt != "" && time.Until(t.expires) > jwtClockDrift {
+		return t.jwt, nil
+	}
+	now := time.Now()
+	jwt, err := signJWT(t.key, strconv.FormatInt(t.appID, 10), now.Add(-jwtClockDrift), now.Add(jwtLifetime))
+	if err != nil {
+		return "", err
+	}
+	t.jwt, t.expires = jwt, now.Add(jwtLifetime)
+	return t.jwt, nil
+}
+
+// signJWT returns a JWT, signed with RS256.
+func signJWT(key *rsa.PrivateKey, issuer string, issuedAt, expiresAt time.Time) (string, error) {
+	header, _ := json.Marshal(struct {
+		Alg string `json:"alg"`
+		Typ string `json:"typ"`
+	}{Alg: "RS256", Typ: "JWT"})
+	claims, _ := json.Marshal(struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}{Iss: issuer, Iat: issuedAt.Unix(), Exp: expiresAt.Unix()})
+
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(payload))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parsePrivateKey parses an RSA private key in PEM format. GitHub generates PKCS #1 keys, but PKCS #8 keys are also accepted.
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA private key")
+	}
+	return rsaKey, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// installationTransport authenticates a request as a GitHub App installation, using an installation access token.
+type installationTransport struct {
+	next           http.RoundTripper
+	apps           Apps
+	expires        time.Time
+	token          string
+	installationID int64
+	lock           sync.Mutex
+}
+
+func (t *installationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.accessToken(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("installation token: %w", err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "token "+token)
+	return t.next.RoundTrip(req)
+}
+
+// accessToken returns the installation access token, requesting a new one if the current one is about to expire.
+func (t *installationTransport) accessToken(ctx context.Context) (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.token != "" && time.Until(t.expires) > tokenRefreshMargin {
+		return t.token, nil
+	}
+	token, _, err := t.apps.CreateInstallationToken(ctx, t.installationID, nil)
+	if err != nil {
+		return "", err
+	}
+	t.token, t.expires = token.GetToken(), token.GetExpiresAt().Time
+	return t.token, nil
+}
@@ -0,0 +1,254 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v78/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewGitHubAppClient(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		pem     []byte
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"pkcs1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), assert.NoError},
+		{"pkcs8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), assert.NoError},
+		{"not pem", []byte("foo"), assert.Error},
+		{"invalid key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("foo")}), assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewGitHubAppClient(Server{}, 1, tt.pem, nil)
+			tt.wantErr(t, err)
+		})
+	}
+}
+
+func TestAppTransport(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var tokens []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	}))
+	t.Cleanup(ts.Close)
+
+	c := http.Client{Transport: &appTransport{appID: 42, key: key, next: http.DefaultTransport}}
+	for range 2 {
+		resp, err := c.Get(ts.URL)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+
+	// the JWT is reused
+	require.Len(t, tokens, 2)
+	assert.Equal(t, tokens[0], tokens[1])
+
+	// the JWT is correctly signed
+	parts := strings.Split(tokens[0], ".")
+	require.Len(t, parts, 3)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature))
+
+	// the JWT has the expected claims
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}
+	require.NoError(t, json.Unmarshal(body, &claims))
+	assert.Equal(t, "42", claims.Iss)
+	assert.LessOrEqual(t, claims.Exp-claims.Iat, int64(10*time.Minute/time.Second))
+}
+
+func TestInstallationTransport(t *testing.T) {
+	apps := fakeApps{lifetime: time.Hour}
+	tr := installationTransport{installationID: 1, apps: &apps}
+
+	// first call requests a token
+	token, err := tr.accessToken(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1-1", token)
+
+	// second call reuses the token
+	token, err = tr.accessToken(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1-1", token)
+
+	// token is refreshed when it's about to expire
+	tr.expires = time.Now().Add(time.Minute)
+	token, err = tr.accessToken(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "token-1-2", token)
+}
+
+func TestAppClient_Stargazers(t *testing.T) {
+	ts := httptest.NewServer(fakeInstallationServer{
+		"token-1-1": {"foo/foo": {"user1", "user2"}},
+		"token-2-1": {"org/bar": {"user3"}, "foo/foo": {"user1", "user2"}},
+	})
+	t.Cleanup(ts.Close)
+
+	tests := []struct {
+		name    string
+		scope   Scope
+		want    []Stargazer
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name:  "all",
+			scope: Scope{All: true},
+			want: []Stargazer{
+				{RepoName: "foo/foo", Login: "user1"},
+				{RepoName: "foo/foo", Login: "user2"},
+				{RepoName: "org/bar", Login: "user3"},
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name:  "owners",
+			scope: Scope{Owners: []string{"org"}},
+			want: []Stargazer{
+				{RepoName: "foo/foo", Login: "user1"},
+				{RepoName: "foo/foo", Login: "user2"},
+				{RepoName: "org/bar", Login: "user3"},
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name:  "owners are case-insensitive",
+			scope: Scope{Owners: []string{"Org"}},
+			want: []Stargazer{
+				{RepoName: "foo/foo", Login: "user1"},
+				{RepoName: "foo/foo", Login: "user2"},
+				{RepoName: "org/bar", Login: "user3"},
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "no match",
+			scope:   Scope{Owners: []string{"org", "bar"}},
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := AppClient{
+				Apps: &fakeApps{
+					lifetime: time.Hour,
+					installations: []*github.Installation{
+						{ID: github.Ptr(int64(1)), Account: &github.User{Login: github.Ptr("foo")}},
+						{ID: github.Ptr(int64(2)), Account: &github.User{Login: github.Ptr("org")}},
+					},
+				},
+				server:  Server{BaseURL: ts.URL},
+				clients: make(map[int64]*Client),
+			}
+			stars, err := c.Stargazers(t.Context(), tt.scope)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, stars)
+		})
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+var _ Apps = &fakeApps{}
+
+type fakeApps struct {
+	installations []*github.Installation
+	calls         map[int64]int
+	lifetime      time.Duration
+	lock          sync.Mutex
+}
+
+func (f *fakeApps) ListInstallations(_ context.Context, _ *github.ListOptions) ([]*github.Installation, *github.Response, error) {
+	return f.installations, &github.Response{}, nil
+}
+
+func (f *fakeApps) CreateInstallationToken(_ context.Context, id int64, _ *github.InstallationTokenOptions) (*github.InstallationToken, *github.Response, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[int64]int)
+	}
+	f.calls[id]++
+	return &github.InstallationToken{
+		Token:     github.Ptr(fmt.Sprintf("token-%d-%d", id, f.calls[id])),
+		ExpiresAt: &github.Timestamp{Time: time.Now().Add(f.lifetime)},
+	}, &github.Response{}, nil
+}
+
+// fakeInstallationServer serves the installation API. It maps each installation token to that installation's repos and their stargazers.
+type fakeInstallationServer map[string]map[string][]string
+
+func (f fakeInstallationServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	repos, ok := f[strings.TrimPrefix(r.Header.Get("Authorization"), "token ")]
+	if !ok {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	// AppClient connects to the server as a GitHub Enterprise Server, which serves its API under /api/v3/
+	path := strings.TrimPrefix(r.URL.Path, "/api/v3")
+	var response any
+	switch {
+	case path == "/installation/repositories":
+		var list github.ListRepositories
+		for repo := range repos {
+			owner, name, _ := strings.Cut(repo, "/")
+			list.Repositories = append(list.Repositories, &github.Repository{
+				FullName: github.Ptr(repo),
+				Name:     github.Ptr(name),
+				Owner:    &github.User{Login: github.Ptr(owner)},
+			})
+		}
+		// map iteration order is random
+		slices.SortFunc(list.Repositories, func(a, b *github.Repository) int {
+			return strings.Compare(a.GetFullName(), b.GetFullName())
+		})
+		response = list
+	case strings.HasPrefix(path, "/repos/") && strings.HasSuffix(path, "/stargazers"):
+		repo := strings.TrimSuffix(strings.TrimPrefix(path, "/repos/"), "/stargazers")
+		var gazers []*github.Stargazer
+		for _, login := range repos[repo] {
+			gazers = append(gazers, &github.Stargazer{User: &github.User{Login: github.Ptr(login)}})
+		}
+		response = gazers
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,224 @@
+package github
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// cacheRetention is how long an unused cache entry is kept.
+const cacheRetention = 30 * 24 * time.Hour
+
+// Cache stores the results of previous GitHub API calls. This allows a Client to send conditional requests,
+// which don't count towards the API rate limit if nothing changed, and to skip repositories whose number of stargazers
+// didn't change since the last scan.
+//
+// A nil Cache is valid and caches nothing.
+type Cache struct {
+	Responses map[string]cachedResponse `json:"responses"`
+	Repos     map[string]cachedRepo     `json:"repos"`
+	path      string
+	lock      sync.Mutex
+}
+
+// cachedResponse is the response to a GET request, along with the validators needed to send a conditional request.
+type cachedResponse struct {
+	LastUsed     time.Time       `json:"last_used"`
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+	Link         string          `json:"link,omitempty"`
+	ContentType  string          `json:"content_type,omitempty"`
+	Body         json.RawMessage `json:"body"`
+}
+
+// cachedRepo holds the stargazers of a repository, as found by the last scan.
+type cachedRepo struct {
+	LastUsed        time.Time   `json:"last_used"`
+	Stargazers      []Stargazer `json:"stargazers"`
+	StargazersCount int         `json:"stargazers_count"`
+}
+
+// NewCache returns a Cache that is persisted to path. If path exists, the Cache is loaded from it.
+func NewCache(path string) (*Cache, error) {
+	c := Cache{
+		Responses: make(map[string]cachedResponse),
+		Repos:     make(map[string]cachedRepo),
+		path:      path,
+	}
+	body, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(body, &c); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return &c, nil
+}
+
+// Save writes the Cache to disk. Entries that weren't used for a while are removed.
+func (c *Cache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for url, response := range c.Responses {
+		if time.Since(response.LastUsed) > cacheRetention {
+			delete(c.Responses, url)
+		}
+	}
+	for name, repo := range c.Repos {
+		if time.Since(repo.LastUsed) > cacheRetention {
+			delete(c.Repos, name)
+		}
+	}
+	body, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	return writeFile(c.path, body)
+}
+
+// writeFile atomically replaces the file at path with data: it writes data to a temporary file and then renames it.
+// A crash leaves either the old or the new version of the file, but never a partial one.
+func writeFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("sync: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	// persist the rename. Not all platforms support syncing a directory, so this is best effort.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+// stargazers returns the cached stargazers of a repository, if the repository's number of stargazers hasn't changed.
+func (c *Cache) stargazers(repoName string, stargazersCount int) ([]Stargazer, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	repo, ok := c.Repos[repoName]
+	if !ok || repo.StargazersCount != stargazersCount {
+		return nil, false
+	}
+	repo.LastUsed = time.Now()
+	c.Repos[repoName] = repo
+	return repo.Stargazers, true
+}
+
+// setStargazers caches the stargazers of a repository.
+func (c *Cache) setStargazers(repoName string, stargazersCount int, stargazers []Stargazer) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.Repos[repoName] = cachedRepo{LastUsed: time.Now(), Stargazers: stargazers, StargazersCount: stargazersCount}
+}
+
+// transport returns an http.RoundTripper that sends conditional GET requests for responses in the Cache.
+func (c *Cache) transport(next http.RoundTripper) http.RoundTripper {
+	if c == nil {
+		return next
+	}
+	return &conditionalTransport{cache: c, next: next}
+}
+
+// conditionalTransport sends conditional GET requests for any response in the Cache. If GitHub responds that the
+// response hasn't changed, conditionalTransport returns the cached response.
+type conditionalTransport struct {
+	cache *Cache
+	next  http.RoundTripper
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.next.RoundTrip(req)
+	}
+	key := req.URL.String()
+
+	t.cache.lock.Lock()
+	cached, ok := t.cache.Responses[key]
+	t.cache.lock.Unlock()
+	if ok {
+		req = req.Clone(req.Context())
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		// return the cached response. Keep the headers of the actual response: these contain the latest rate limits.
+		_ = resp.Body.Close()
+		resp.StatusCode = http.StatusOK
+		resp.Status = http.StatusText(http.StatusOK)
+		resp.Header.Set("Link", cached.Link)
+		resp.Header.Set("Content-Type", cached.ContentType)
+		resp.Body = io.NopCloser(bytes.NewReader(cached.Body))
+		resp.ContentLength = int64(len(cached.Body))
+		cached.LastUsed = time.Now()
+	case resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""):
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if !json.Valid(body) {
+			return resp, nil
+		}
+		cached = cachedResponse{
+			LastUsed:     time.Now(),
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Link:         resp.Header.Get("Link"),
+			ContentType:  resp.Header.Get("Content-Type"),
+			Body:         body,
+		}
+	default:
+		return resp, nil
+	}
+
+	t.cache.lock.Lock()
+	t.cache.Responses[key] = cached
+	t.cache.lock.Unlock()
+	return resp, nil
+}
@@ -0,0 +1,107 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-github/v78/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalTransport(t *testing.T) {
+	var full, notModified atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "4000")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Link", `<`+"http://"+r.Host+r.URL.Path+`?page=2>; rel="next"`)
+		_, _ = w.Write([]byte(`[{"user":{"login":"user1"}}]`))
+	}))
+	t.Cleanup(ts.Close)
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	cache, err := NewCache(path)
+	require.NoError(t, err)
+
+	client := github.NewClient(&http.Client{Transport: cache.transport(http.DefaultTransport)})
+	client.BaseURL, _ = url.Parse(ts.URL + "/")
+
+	for range 2 {
+		gazers, resp, err := client.Activity.ListStargazers(t.Context(), "foo", "bar", nil)
+		require.NoError(t, err)
+		require.Len(t, gazers, 1)
+		assert.Equal(t, "user1", gazers[0].GetUser().GetLogin())
+		assert.Equal(t, 2, resp.NextPage)
+		assert.Equal(t, 4000, resp.Rate.Remaining)
+	}
+	assert.Equal(t, int32(1), full.Load())
+	assert.Equal(t, int32(1), notModified.Load())
+
+	// the cache survives a restart
+	require.NoError(t, cache.Save())
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "temporary file not removed")
+	cache, err = NewCache(path)
+	require.NoError(t, err)
+	client = github.NewClient(&http.Client{Transport: cache.transport(http.DefaultTransport)})
+	client.BaseURL, _ = url.Parse(ts.URL + "/")
+	_, _, err = client.Activity.ListStargazers(t.Context(), "foo", "bar", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), full.Load())
+	assert.Equal(t, int32(2), notModified.Load())
+}
+
+func TestNewCache_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	require.NoError(t, os.WriteFile(path, []byte("invalid"), 0o644))
+	_, err := NewCache(path)
+	assert.Error(t, err)
+}
+
+func TestClient_Stargazers_Cache(t *testing.T) {
+	cache, err := NewCache(filepath.Join(t.TempDir(), "cache.json"))
+	require.NoError(t, err)
+	var activity countingActivity
+	client, err := NewGitHubClient(Server{}, "", cache)
+	require.NoError(t, err)
+	client.Repositories = fakeRepositories{}
+	client.Activity = &activity
+	client.Users = fakeUsers{}
+
+	// first scan retrieves all stargazers
+	want, err := client.Stargazers(t.Context(), Scope{Owners: []string{"foo"}})
+	require.NoError(t, err)
+	calls := activity.calls.Load()
+	assert.NotZero(t, calls)
+
+	// second scan: stargazer counts haven't changed. No stargazers are retrieved.
+	got, err := client.Stargazers(t.Context(), Scope{Owners: []string{"foo"}})
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, calls, activity.calls.Load())
+}
+
+type countingActivity struct {
+	fakeActivity
+	calls atomic.Int32
+}
+
+func (c *countingActivity) ListStargazers(ctx context.Context, owner string, repo string, opts *github.ListOptions) ([]*github.Stargazer, *github.Response, error) {
+	c.calls.Add(1)
+	return c.fakeActivity.ListStargazers(ctx, owner, repo, opts)
+}
@@ -2,31 +2,90 @@ package github
 
 import (
 	"context"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/clambin/github-stars/slogctx"
 	"github.com/google/go-github/v78/github"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Client struct {
 	Repositories
 	Activity
+	Users
+	// Installation lists the repositories of a GitHub App installation. Only set if Client authenticates as an installation.
+	Installation Installation
+	// RateLimiter retries calls that fail because of rate limiting or transient errors. If nil, calls are not retried.
+	RateLimiter *RateLimiter
+	// Cache holds the stargazers found by the previous scan. If nil, all stargazers are retrieved on every scan.
+	Cache *Cache
+	// Workers is the number of repositories whose stargazers are retrieved in parallel. Default is 1.
+	Workers int
 }
 
 type Repositories interface {
 	ListByUser(ctx context.Context, user string, opts *github.RepositoryListByUserOptions) ([]*github.Repository, *github.Response, error)
+	ListByOrg(ctx context.Context, org string, opts *github.RepositoryListByOrgOptions) ([]*github.Repository, *github.Response, error)
+	ListByAuthenticatedUser(ctx context.Context, opts *github.RepositoryListByAuthenticatedUserOptions) ([]*github.Repository, *github.Response, error)
 }
 
 type Activity interface {
 	ListStargazers(ctx context.Context, owner string, repo string, opts *github.ListOptions) ([]*github.Stargazer, *github.Response, error)
 }
 
-func NewGitHubClient(token string) *Client {
-	client := github.NewClient(nil).WithAuthToken(token)
+type Users interface {
+	Get(ctx context.Context, user string) (*github.User, *github.Response, error)
+}
+
+type Installation interface {
+	ListRepos(ctx context.Context, opts *github.ListOptions) (*github.ListRepositories, *github.Response, error)
+}
+
+// NewGitHubClient returns a Client for the GitHub server that authenticates with the provided token.
+// If cache is not nil, the Client uses it to avoid retrieving unchanged data from GitHub.
+func NewGitHubClient(server Server, token string, cache *Cache) (*Client, error) {
+	transport, err := server.transport()
+	if err != nil {
+		return nil, err
+	}
+	client, err := server.client(cache.transport(transport))
+	if err != nil {
+		return nil, err
+	}
+	client = client.WithAuthToken(token)
 	return &Client{
 		Repositories: client.Repositories,
 		Activity:     client.Activity,
-	}
+		Users:        client.Users,
+		RateLimiter:  &RateLimiter{},
+		Cache:        cache,
+	}, nil
+}
+
+var _ prometheus.Collector = Client{}
+
+// Describe implements prometheus.Collector.
+func (c Client) Describe(ch chan<- *prometheus.Desc) {
+	c.RateLimiter.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c Client) Collect(ch chan<- prometheus.Metric) {
+	c.RateLimiter.Collect(ch)
+}
+
+// Scope determines which repositories to scan.
+type Scope struct {
+	// Owners are the users and organizations whose repositories to scan.
+	Owners []string
+	// All scans all repositories the client has access to, in addition to the repositories of Owners.
+	// For a GitHub App, these are the repositories of all its installations.
+	All bool
+	// IncludeArchived includes archived repositories.
+	IncludeArchived bool
 }
 
 // Stargazer represents a star from one user for one repository.
@@ -37,74 +96,202 @@ type Stargazer struct {
 	RepoHTMLURL string    `json:"repo_html_url"`
 	Login       string    `json:"login"`
 	UserHTMLURL string    `json:"user_html_url"`
+	// RepoTopics are the topics of the repository. They aren't stored with the stargazer.
+	RepoTopics []string `json:"-"`
 }
 
-// Stargazers returns the list of stargazers for a user's repositories.
-// If includeArchived is true, archived repositories are included.
-func (c Client) Stargazers(ctx context.Context, user string, includeArchived bool) ([]Stargazer, error) {
+// Stargazers returns the list of stargazers for all repositories in scope.
+//
+// If the Client has a Cache, Stargazers only retrieves the stargazers of repositories whose number of stargazers changed
+// since the previous scan. Note that this misses the case where one user removed their star and another one added one
+// in between two scans. Those changes are still received through the webhook.
+func (c Client) Stargazers(ctx context.Context, scope Scope) ([]Stargazer, error) {
+	repos, err := c.repos(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	if !scope.IncludeArchived {
+		repos = slices.DeleteFunc(repos, func(repo *github.Repository) bool { return repo.GetArchived() })
+	}
+
+	// retrieve the stargazers of up to c.Workers repositories in parallel. The first error cancels all other workers.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	results := make([][]Stargazer, len(repos))
+	workers := make(chan struct{}, max(c.Workers, 1))
+	var wg sync.WaitGroup
+	for i, repo := range repos {
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Go(func() {
+			defer func() { <-workers }()
+			gazers, err := c.repoStargazers(ctx, repo)
+			if err != nil {
+				cancel(err)
+				return
+			}
+			results[i] = gazers
+		})
+	}
+	wg.Wait()
+	if err = context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
 	var stargazers []Stargazer
+	for _, gazers := range results {
+		stargazers = append(stargazers, gazers...)
+	}
+	if err = c.Cache.Save(); err != nil {
+		slogctx.FromContext(ctx).Warn("failed to save GitHub cache", "err", err)
+	}
+	return stargazers, nil
+}
 
-	repos, err := c.userRepos(ctx, user)
+// repoStargazers returns the stargazers of a repository.
+func (c Client) repoStargazers(ctx context.Context, repo *github.Repository) ([]Stargazer, error) {
+	if stargazers, ok := c.Cache.stargazers(repo.GetFullName(), repo.GetStargazersCount()); ok {
+		return withTopics(stargazers, repo.Topics), nil
+	}
+	gazers, err := c.starGazers(ctx, repo)
 	if err != nil {
 		return nil, err
 	}
-	for _, repo := range repos {
-		if repo.GetArchived() && !includeArchived {
-			continue
+	stargazers := make([]Stargazer, 0, len(gazers))
+	for _, gazer := range gazers {
+		stargazers = append(stargazers, Stargazer{
+			RepoName:    repo.GetFullName(),
+			RepoHTMLURL: repo.GetHTMLURL(),
+			Login:       gazer.GetUser().GetLogin(),
+			UserHTMLURL: gazer.GetUser().GetHTMLURL(),
+			StarredAt:   gazer.GetStarredAt().Time,
+			RepoTopics:  repo.Topics,
+		})
+	}
+	c.Cache.setStargazers(repo.GetFullName(), repo.GetStargazersCount(), stargazers)
+	return stargazers, nil
+}
+
+// withTopics sets the repository topics of the stargazers. Topics may have changed since the stargazers were cached.
+func withTopics(stargazers []Stargazer, topics []string) []Stargazer {
+	out := make([]Stargazer, len(stargazers))
+	for i, stargazer := range stargazers {
+		stargazer.RepoTopics = topics
+		out[i] = stargazer
+	}
+	return out
+}
+
+const recordsPerPage = 100
+
+// repos returns all repositories in scope. A repository that is listed more than once is only returned once.
+func (c Client) repos(ctx context.Context, scope Scope) ([]*github.Repository, error) {
+	var repos []*github.Repository
+	seen := make(map[string]struct{})
+	add := func(page []*github.Repository) {
+		for _, repo := range page {
+			if _, ok := seen[repo.GetFullName()]; !ok {
+				seen[repo.GetFullName()] = struct{}{}
+				repos = append(repos, repo)
+			}
 		}
-		gazers, err := c.starGazers(ctx, repo)
+	}
+
+	if scope.All {
+		page, err := c.accessibleRepos(ctx)
 		if err != nil {
 			return nil, err
 		}
-		for _, gazer := range gazers {
-			stargazers = append(stargazers, Stargazer{
-				RepoName:    repo.GetFullName(),
-				RepoHTMLURL: repo.GetHTMLURL(),
-				Login:       gazer.GetUser().GetLogin(),
-				UserHTMLURL: gazer.GetUser().GetHTMLURL(),
-				StarredAt:   gazer.GetStarredAt().Time,
-			})
+		add(page)
+	}
+	for _, owner := range scope.Owners {
+		page, err := c.ownerRepos(ctx, owner)
+		if err != nil {
+			return nil, err
 		}
+		add(page)
 	}
-	return stargazers, nil
+	return repos, nil
 }
 
-const recordsPerPage = 100
+// ownerRepos returns the repositories of a user or organization.
+func (c Client) ownerRepos(ctx context.Context, owner string) ([]*github.Repository, error) {
+	user, _, err := call(ctx, c.RateLimiter, func() (*github.User, *github.Response, error) {
+		return c.Users.Get(ctx, owner)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user.GetType() == "Organization" {
+		return c.orgRepos(ctx, owner)
+	}
+	return c.userRepos(ctx, owner)
+}
 
 func (c Client) userRepos(ctx context.Context, user string) ([]*github.Repository, error) {
-	var repos []*github.Repository
 	listOptions := github.RepositoryListByUserOptions{ListOptions: github.ListOptions{PerPage: recordsPerPage}}
+	return paginate(ctx, c.RateLimiter, &listOptions.ListOptions, func() ([]*github.Repository, *github.Response, error) {
+		return c.ListByUser(ctx, user, &listOptions)
+	})
+}
 
-	for {
-		var resp *github.Response
-		// TODO: ListByAuthenticatedUser() could list all repos the user (token) has access to?
-		repoPage, resp, err := c.ListByUser(ctx, user, &listOptions)
+func (c Client) orgRepos(ctx context.Context, org string) ([]*github.Repository, error) {
+	listOptions := github.RepositoryListByOrgOptions{ListOptions: github.ListOptions{PerPage: recordsPerPage}}
+	return paginate(ctx, c.RateLimiter, &listOptions.ListOptions, func() ([]*github.Repository, *github.Response, error) {
+		return c.ListByOrg(ctx, org, &listOptions)
+	})
+}
+
+// accessibleRepos returns all repositories the client has access to.
+func (c Client) accessibleRepos(ctx context.Context) ([]*github.Repository, error) {
+	if c.Installation != nil {
+		return c.installationRepos(ctx)
+	}
+	listOptions := github.RepositoryListByAuthenticatedUserOptions{ListOptions: github.ListOptions{PerPage: recordsPerPage}}
+	return paginate(ctx, c.RateLimiter, &listOptions.ListOptions, func() ([]*github.Repository, *github.Response, error) {
+		return c.ListByAuthenticatedUser(ctx, &listOptions)
+	})
+}
+
+func (c Client) installationRepos(ctx context.Context) ([]*github.Repository, error) {
+	listOptions := github.ListOptions{PerPage: recordsPerPage}
+	return paginate(ctx, c.RateLimiter, &listOptions, func() ([]*github.Repository, *github.Response, error) {
+		repos, resp, err := c.Installation.ListRepos(ctx, &listOptions)
 		if err != nil {
-			return nil, err
-		}
-		repos = append(repos, repoPage...)
-		if resp.NextPage == 0 {
-			return repos, nil
+			return nil, nil, err
 		}
-		listOptions.Page = resp.NextPage
-	}
+		return repos.Repositories, resp, nil
+	})
 }
 
 func (c Client) starGazers(ctx context.Context, repo *github.Repository) ([]*github.Stargazer, error) {
-	var starGazers []*github.Stargazer
 	listOptions := github.ListOptions{PerPage: recordsPerPage}
 
 	// repo.Owner.GetLogin() ???
 	user := strings.TrimSuffix(repo.GetFullName(), "/"+repo.GetName())
 
+	return paginate(ctx, c.RateLimiter, &listOptions, func() ([]*github.Stargazer, *github.Response, error) {
+		return c.ListStargazers(ctx, user, repo.GetName(), &listOptions)
+	})
+}
+
+// paginate calls list until all pages have been retrieved. list must use listOptions to determine which page to retrieve.
+// Each call to list is retried as per the RateLimiter.
+func paginate[T any](ctx context.Context, r *RateLimiter, listOptions *github.ListOptions, list func() ([]T, *github.Response, error)) ([]T, error) {
+	var all []T
 	for {
-		page, resp, err := c.ListStargazers(ctx, user, repo.GetName(), &listOptions)
+		page, resp, err := call(ctx, r, list)
 		if err != nil {
 			return nil, err
 		}
-		starGazers = append(starGazers, page...)
+		all = append(all, page...)
 		if resp.NextPage == 0 {
-			return starGazers, nil
+			return all, nil
 		}
 		listOptions.Page = resp.NextPage
 	}
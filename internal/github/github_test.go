@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,19 +14,69 @@ import (
 )
 
 func TestClient_Stars(t *testing.T) {
-	client := NewGitHubClient("")
-	client.Repositories = fakeRepositories{}
-	client.Activity = fakeActivity{}
+	tests := []struct {
+		name  string
+		scope Scope
+		want  []Stargazer
+	}{
+		{
+			name:  "user",
+			scope: Scope{Owners: []string{"foo"}},
+			want: []Stargazer{
+				{RepoName: "foo/foo", Login: "user1", StarredAt: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC)},
+				{RepoName: "foo/foo", Login: "user2", StarredAt: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:  "organization",
+			scope: Scope{Owners: []string{"org"}},
+			want: []Stargazer{
+				{RepoName: "org/baz", Login: "user3", StarredAt: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC), RepoTopics: []string{"infra"}},
+			},
+		},
+		{
+			name:  "all",
+			scope: Scope{All: true, Owners: []string{"foo", "org"}},
+			want: []Stargazer{
+				{RepoName: "foo/foo", Login: "user1", StarredAt: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC)},
+				{RepoName: "foo/foo", Login: "user2", StarredAt: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC)},
+				{RepoName: "org/baz", Login: "user3", StarredAt: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC), RepoTopics: []string{"infra"}},
+			},
+		},
+		{
+			name:  "archived",
+			scope: Scope{Owners: []string{"org"}, IncludeArchived: true},
+			want: []Stargazer{
+				{RepoName: "org/baz", Login: "user3", StarredAt: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC), RepoTopics: []string{"infra"}},
+				{RepoName: "org/qux", Login: "user4", StarredAt: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC)},
+			},
+		},
+	}
 
-	stars, err := client.Stargazers(context.Background(), "bar", true)
-	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewGitHubClient(Server{}, "", nil)
+			require.NoError(t, err)
+			client.Repositories = fakeRepositories{}
+			client.Activity = fakeActivity{}
+			client.Users = fakeUsers{}
 
-	want := []Stargazer{
-		{RepoName: "foo/foo", Login: "user1", StarredAt: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC)},
-		{RepoName: "foo/foo", Login: "user2", StarredAt: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC)},
+			stars, err := client.Stargazers(context.Background(), tt.scope)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, stars)
+		})
 	}
+}
+
+func TestClient_Stars_Error(t *testing.T) {
+	client, err := NewGitHubClient(Server{}, "", nil)
+	require.NoError(t, err)
+	client.Repositories = fakeRepositories{}
+	client.Activity = fakeActivity{}
+	client.Users = fakeUsers{}
 
-	assert.Equal(t, want, stars)
+	_, err = client.Stargazers(context.Background(), Scope{Owners: []string{"unknown"}})
+	assert.Error(t, err)
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -43,6 +94,23 @@ func (f fakeRepositories) ListByUser(_ context.Context, _ string, opts *github.R
 	return resp.repos, resp.resp, nil
 }
 
+func (f fakeRepositories) ListByOrg(_ context.Context, org string, _ *github.RepositoryListByOrgOptions) ([]*github.Repository, *github.Response, error) {
+	if org != "org" {
+		return nil, nil, errors.New("org not found")
+	}
+	return []*github.Repository{
+		{FullName: github.Ptr("org/baz"), Name: github.Ptr("baz"), Topics: []string{"infra"}},
+		{FullName: github.Ptr("org/qux"), Name: github.Ptr("qux"), Archived: github.Ptr(true)},
+	}, &github.Response{}, nil
+}
+
+func (f fakeRepositories) ListByAuthenticatedUser(_ context.Context, _ *github.RepositoryListByAuthenticatedUserOptions) ([]*github.Repository, *github.Response, error) {
+	return []*github.Repository{
+		{FullName: github.Ptr("foo/foo"), Name: github.Ptr("foo")},
+		{FullName: github.Ptr("org/baz"), Name: github.Ptr("baz"), Topics: []string{"infra"}},
+	}, &github.Response{}, nil
+}
+
 type repoResponsePage struct {
 	repos []*github.Repository
 	resp  *github.Response
@@ -86,6 +154,24 @@ var listStargazersResponses = map[string]map[int]stargazerResponse{
 	"bar": {
 		0: {resp: &github.Response{NextPage: 0}},
 	},
+	"baz": {
+		0: {
+			gazers: []*github.Stargazer{{
+				StarredAt: &github.Timestamp{Time: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC)},
+				User:      &github.User{Login: github.Ptr("user3")},
+			}},
+			resp: &github.Response{NextPage: 0},
+		},
+	},
+	"qux": {
+		0: {
+			gazers: []*github.Stargazer{{
+				StarredAt: &github.Timestamp{Time: time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC)},
+				User:      &github.User{Login: github.Ptr("user4")},
+			}},
+			resp: &github.Response{NextPage: 0},
+		},
+	},
 }
 
 var _ Activity = &fakeActivity{}
@@ -103,3 +189,106 @@ func (f fakeActivity) ListStargazers(_ context.Context, _ string, repo string, o
 	}
 	return repoResp.gazers, repoResp.resp, nil
 }
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+var _ Users = &fakeUsers{}
+
+type fakeUsers struct{}
+
+func (f fakeUsers) Get(_ context.Context, user string) (*github.User, *github.Response, error) {
+	switch user {
+	case "foo":
+		return &github.User{Login: github.Ptr(user), Type: github.Ptr("User")}, &github.Response{}, nil
+	case "org":
+		return &github.User{Login: github.Ptr(user), Type: github.Ptr("Organization")}, &github.Response{}, nil
+	default:
+		return nil, nil, fmt.Errorf("user not found: %s", user)
+	}
+}
+
+func TestClient_Stars_Workers(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		failOn  string
+	}{
+		{name: "sequential", workers: 1},
+		{name: "parallel", workers: 4},
+		{name: "error", workers: 4, failOn: "repo-05"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			activity := slowActivity{failOn: tt.failOn}
+			client, err := NewGitHubClient(Server{}, "", nil)
+			require.NoError(t, err)
+			client.Repositories = manyRepositories(20)
+			client.Activity = &activity
+			client.Users = fakeUsers{}
+			client.Workers = tt.workers
+
+			stars, err := client.Stargazers(t.Context(), Scope{Owners: []string{"foo"}})
+			if tt.failOn != "" {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			// output is in the order of the repositories, regardless of the number of workers
+			require.Len(t, stars, 20)
+			for i, star := range stars {
+				assert.Equal(t, fmt.Sprintf("foo/repo-%02d", i), star.RepoName)
+			}
+			assert.Equal(t, int32(tt.workers), activity.maxInFlight.Load())
+		})
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// manyRepositories returns the requested number of repositories for any user.
+type manyRepositories int
+
+func (m manyRepositories) ListByUser(_ context.Context, user string, _ *github.RepositoryListByUserOptions) ([]*github.Repository, *github.Response, error) {
+	repos := make([]*github.Repository, m)
+	for i := range repos {
+		name := fmt.Sprintf("repo-%02d", i)
+		repos[i] = &github.Repository{FullName: github.Ptr(user + "/" + name), Name: github.Ptr(name)}
+	}
+	return repos, &github.Response{}, nil
+}
+
+func (m manyRepositories) ListByOrg(context.Context, string, *github.RepositoryListByOrgOptions) ([]*github.Repository, *github.Response, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func (m manyRepositories) ListByAuthenticatedUser(context.Context, *github.RepositoryListByAuthenticatedUserOptions) ([]*github.Repository, *github.Response, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+// slowActivity returns one stargazer per repository, after a short delay. It records the maximum number of parallel calls.
+type slowActivity struct {
+	failOn      string
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func (s *slowActivity) ListStargazers(ctx context.Context, _ string, repo string, _ *github.ListOptions) ([]*github.Stargazer, *github.Response, error) {
+	current := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		if old := s.maxInFlight.Load(); current <= old || s.maxInFlight.CompareAndSwap(old, current) {
+			break
+		}
+	}
+	if repo == s.failOn {
+		return nil, nil, errors.New("failed")
+	}
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case <-time.After(10 * time.Millisecond):
+	}
+	return []*github.Stargazer{{User: &github.User{Login: github.Ptr("user")}}}, &github.Response{}, nil
+}
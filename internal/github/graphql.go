@@ -0,0 +1,350 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clambin/github-stars/slogctx"
+	"github.com/google/go-github/v78/github"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GraphQLClient retrieves stargazers through the GitHub GraphQL API. Each query returns a page of repositories,
+// along with the first page of stargazers of each repository. For accounts with many repositories, this requires
+// far fewer API calls than the REST API.
+type GraphQLClient struct {
+	GraphQL
+	// RateLimiter retries calls that fail because of rate limiting or transient errors. If nil, calls are not retried.
+	RateLimiter *RateLimiter
+	// Cache holds the stargazers found by the previous scan. If nil, all stargazers are retrieved on every scan.
+	Cache *Cache
+}
+
+// GraphQL executes a GraphQL query and decodes the response's data into result.
+type GraphQL interface {
+	Query(ctx context.Context, query string, variables map[string]any, result any) (*github.Response, error)
+}
+
+// NewGitHubGraphQLClient returns a GraphQLClient for the GitHub server that authenticates with the provided token.
+func NewGitHubGraphQLClient(server Server, token string, cache *Cache) (*GraphQLClient, error) {
+	transport, err := server.transport()
+	if err != nil {
+		return nil, err
+	}
+	client, err := server.client(transport)
+	if err != nil {
+		return nil, err
+	}
+	graphQLURL, err := server.graphQLURL()
+	if err != nil {
+		return nil, err
+	}
+	return &GraphQLClient{
+		GraphQL:     graphQLService{client: client.WithAuthToken(token), url: graphQLURL},
+		RateLimiter: &RateLimiter{},
+		Cache:       cache,
+	}, nil
+}
+
+var _ prometheus.Collector = GraphQLClient{}
+
+// Describe implements prometheus.Collector.
+func (c GraphQLClient) Describe(ch chan<- *prometheus.Desc) {
+	c.RateLimiter.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c GraphQLClient) Collect(ch chan<- prometheus.Metric) {
+	c.RateLimiter.Collect(ch)
+}
+
+const (
+	graphQLReposPerPage = 50
+
+	stargazersFragment = `
+fragment stargazers on StargazerConnection {
+	pageInfo { hasNextPage endCursor }
+	edges { starredAt node { login url } }
+}`
+
+	repositoriesFragment = `
+fragment repositories on RepositoryConnection {
+	pageInfo { hasNextPage endCursor }
+	nodes {
+		nameWithOwner url isArchived stargazerCount
+		repositoryTopics(first: 20) { nodes { topic { name } } }
+		stargazers(first: 100, orderBy: {field: STARRED_AT, direction: ASC}) { ...stargazers }
+	}
+}`
+
+	ownerRepositoriesQuery = `
+query($owner: String!, $first: Int!, $cursor: String) {
+	repositoryOwner(login: $owner) {
+		repositories(first: $first, after: $cursor, ownerAffiliations: [OWNER]) { ...repositories }
+	}
+}` + repositoriesFragment + stargazersFragment
+
+	viewerRepositoriesQuery = `
+query($first: Int!, $cursor: String) {
+	viewer {
+		repositories(first: $first, after: $cursor, ownerAffiliations: [OWNER, COLLABORATOR, ORGANIZATION_MEMBER]) { ...repositories }
+	}
+}` + repositoriesFragment + stargazersFragment
+
+	stargazersQuery = `
+query($owner: String!, $name: String!, $cursor: String) {
+	repository(owner: $owner, name: $name) {
+		stargazers(first: 100, after: $cursor, orderBy: {field: STARRED_AT, direction: ASC}) { ...stargazers }
+	}
+}` + stargazersFragment
+)
+
+type graphQLPageInfo struct {
+	EndCursor   string `json:"endCursor"`
+	HasNextPage bool   `json:"hasNextPage"`
+}
+
+type graphQLRepositories struct {
+	PageInfo graphQLPageInfo     `json:"pageInfo"`
+	Nodes    []graphQLRepository `json:"nodes"`
+}
+
+type graphQLRepository struct {
+	NameWithOwner    string `json:"nameWithOwner"`
+	URL              string `json:"url"`
+	RepositoryTopics struct {
+		Nodes []struct {
+			Topic struct {
+				Name string `json:"name"`
+			} `json:"topic"`
+		} `json:"nodes"`
+	} `json:"repositoryTopics"`
+	Stargazers     graphQLStargazers `json:"stargazers"`
+	StargazerCount int               `json:"stargazerCount"`
+	IsArchived     bool              `json:"isArchived"`
+}
+
+type graphQLStargazers struct {
+	PageInfo graphQLPageInfo `json:"pageInfo"`
+	Edges    []struct {
+		StarredAt time.Time `json:"starredAt"`
+		Node      struct {
+			Login string `json:"login"`
+			URL   string `json:"url"`
+		} `json:"node"`
+	} `json:"edges"`
+}
+
+// Stargazers returns the list of stargazers for all repositories in scope.
+func (c GraphQLClient) Stargazers(ctx context.Context, scope Scope) ([]Stargazer, error) {
+	repos, err := c.repos(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	var stargazers []Stargazer
+	for _, repo := range repos {
+		if repo.IsArchived && !scope.IncludeArchived {
+			continue
+		}
+		gazers, err := c.repoStargazers(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+		stargazers = append(stargazers, gazers...)
+	}
+	if err = c.Cache.Save(); err != nil {
+		slogctx.FromContext(ctx).Warn("failed to save GitHub cache", "err", err)
+	}
+	return stargazers, nil
+}
+
+// repos returns all repositories in scope. A repository that is listed more than once is only returned once.
+func (c GraphQLClient) repos(ctx context.Context, scope Scope) ([]graphQLRepository, error) {
+	var repos []graphQLRepository
+	seen := make(map[string]struct{})
+	add := func(page []graphQLRepository) {
+		for _, repo := range page {
+			if _, ok := seen[repo.NameWithOwner]; !ok {
+				seen[repo.NameWithOwner] = struct{}{}
+				repos = append(repos, repo)
+			}
+		}
+	}
+
+	if scope.All {
+		page, err := c.repositories(ctx, viewerRepositoriesQuery, nil, func(data json.RawMessage) (graphQLRepositories, error) {
+			var response struct {
+				Viewer struct {
+					Repositories graphQLRepositories `json:"repositories"`
+				} `json:"viewer"`
+			}
+			err := json.Unmarshal(data, &response)
+			return response.Viewer.Repositories, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		add(page)
+	}
+	for _, owner := range scope.Owners {
+		page, err := c.repositories(ctx, ownerRepositoriesQuery, map[string]any{"owner": owner}, func(data json.RawMessage) (graphQLRepositories, error) {
+			var response struct {
+				RepositoryOwner *struct {
+					Repositories graphQLRepositories `json:"repositories"`
+				} `json:"repositoryOwner"`
+			}
+			if err := json.Unmarshal(data, &response); err != nil {
+				return graphQLRepositories{}, err
+			}
+			if response.RepositoryOwner == nil {
+				return graphQLRepositories{}, errors.New("owner not found: " + owner)
+			}
+			return response.RepositoryOwner.Repositories, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		add(page)
+	}
+	return repos, nil
+}
+
+// repositories runs a repository query until all pages have been retrieved. extract gets the repositories from the query's data.
+func (c GraphQLClient) repositories(ctx context.Context, query string, variables map[string]any, extract func(json.RawMessage) (graphQLRepositories, error)) ([]graphQLRepository, error) {
+	vars := map[string]any{"first": graphQLReposPerPage}
+	for k, v := range variables {
+		vars[k] = v
+	}
+	var repos []graphQLRepository
+	for {
+		data, _, err := call(ctx, c.RateLimiter, func() (json.RawMessage, *github.Response, error) {
+			var data json.RawMessage
+			resp, err := c.Query(ctx, query, vars, &data)
+			return data, resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		page, err := extract(data)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, page.Nodes...)
+		if !page.PageInfo.HasNextPage {
+			return repos, nil
+		}
+		vars["cursor"] = page.PageInfo.EndCursor
+	}
+}
+
+// repoStargazers returns the stargazers of a repository. If the repository has more stargazers than were returned
+// by the repository query, the remaining stargazers are retrieved, unless they can be found in the Cache.
+func (c GraphQLClient) repoStargazers(ctx context.Context, repo graphQLRepository) ([]Stargazer, error) {
+	if !repo.Stargazers.PageInfo.HasNextPage {
+		return repo.stargazers(repo.Stargazers), nil
+	}
+	if stargazers, ok := c.Cache.stargazers(repo.NameWithOwner, repo.StargazerCount); ok {
+		return withTopics(stargazers, repo.topics()), nil
+	}
+
+	owner, name, _ := strings.Cut(repo.NameWithOwner, "/")
+	stargazers := repo.stargazers(repo.Stargazers)
+	variables := map[string]any{"owner": owner, "name": name, "cursor": repo.Stargazers.PageInfo.EndCursor}
+	for {
+		var response struct {
+			Repository struct {
+				Stargazers graphQLStargazers `json:"stargazers"`
+			} `json:"repository"`
+		}
+		if _, _, err := call(ctx, c.RateLimiter, func() (any, *github.Response, error) {
+			resp, err := c.Query(ctx, stargazersQuery, variables, &response)
+			return nil, resp, err
+		}); err != nil {
+			return nil, err
+		}
+		stargazers = append(stargazers, repo.stargazers(response.Repository.Stargazers)...)
+		if !response.Repository.Stargazers.PageInfo.HasNextPage {
+			break
+		}
+		variables["cursor"] = response.Repository.Stargazers.PageInfo.EndCursor
+	}
+	c.Cache.setStargazers(repo.NameWithOwner, repo.StargazerCount, stargazers)
+	return stargazers, nil
+}
+
+// topics returns the repository's topics.
+func (r graphQLRepository) topics() []string {
+	if len(r.RepositoryTopics.Nodes) == 0 {
+		return nil
+	}
+	topics := make([]string, len(r.RepositoryTopics.Nodes))
+	for i, node := range r.RepositoryTopics.Nodes {
+		topics[i] = node.Topic.Name
+	}
+	return topics
+}
+
+// stargazers converts a page of stargazers of the repository to Stargazer records.
+func (r graphQLRepository) stargazers(page graphQLStargazers) []Stargazer {
+	topics := r.topics()
+	stargazers := make([]Stargazer, 0, len(page.Edges))
+	for _, edge := range page.Edges {
+		stargazers = append(stargazers, Stargazer{
+			StarredAt:   edge.StarredAt,
+			RepoName:    r.NameWithOwner,
+			RepoHTMLURL: r.URL,
+			Login:       edge.Node.Login,
+			UserHTMLURL: edge.Node.URL,
+			RepoTopics:  topics,
+		})
+	}
+	return stargazers
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// graphQLService sends GraphQL queries through a go-github client, which takes care of authentication and parsing
+// the rate limit headers.
+type graphQLService struct {
+	client *github.Client
+	url    string
+}
+
+type graphQLError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+func (s graphQLService) Query(ctx context.Context, query string, variables map[string]any, result any) (*github.Response, error) {
+	req, err := s.client.NewRequest(http.MethodPost, s.url, struct {
+		Variables map[string]any `json:"variables,omitempty"`
+		Query     string         `json:"query"`
+	}{Query: query, Variables: variables})
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []graphQLError  `json:"errors"`
+	}
+	resp, err := s.client.Do(ctx, req, &response)
+	if err != nil {
+		return resp, err
+	}
+	// GraphQL reports errors in the response body, rather than through the HTTP status code.
+	if len(response.Errors) > 0 {
+		if response.Errors[0].Type == "RATE_LIMITED" {
+			return resp, &github.RateLimitError{Rate: resp.Rate, Response: resp.Response, Message: response.Errors[0].Message}
+		}
+		msgs := make([]string, len(response.Errors))
+		for i, e := range response.Errors {
+			msgs[i] = e.Message
+		}
+		return resp, errors.New("graphql: " + strings.Join(msgs, "; "))
+	}
+	return resp, json.Unmarshal(response.Data, result)
+}
@@ -0,0 +1,140 @@
+package github
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v78/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphQLClient_Stargazers(t *testing.T) {
+	ts := httptest.NewServer(fakeGraphQLServer{})
+	t.Cleanup(ts.Close)
+
+	starredAt := time.Date(2024, time.November, 19, 21, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		scope   Scope
+		wantErr assert.ErrorAssertionFunc
+		want    []Stargazer
+	}{
+		{
+			name:    "owner",
+			scope:   Scope{Owners: []string{"foo"}},
+			wantErr: assert.NoError,
+			want: []Stargazer{
+				{StarredAt: starredAt, RepoName: "foo/foo", RepoHTMLURL: "https://github.com/foo/foo", Login: "user1", UserHTMLURL: "https://github.com/user1"},
+			},
+		},
+		{
+			name:    "archived",
+			scope:   Scope{Owners: []string{"foo"}, IncludeArchived: true},
+			wantErr: assert.NoError,
+			want: []Stargazer{
+				{StarredAt: starredAt, RepoName: "foo/foo", RepoHTMLURL: "https://github.com/foo/foo", Login: "user1", UserHTMLURL: "https://github.com/user1"},
+				{StarredAt: starredAt, RepoName: "foo/archived", RepoHTMLURL: "https://github.com/foo/archived", Login: "user2", UserHTMLURL: "https://github.com/user2"},
+			},
+		},
+		{
+			name:    "all",
+			scope:   Scope{All: true, Owners: []string{"foo"}},
+			wantErr: assert.NoError,
+			want: []Stargazer{
+				{StarredAt: starredAt, RepoName: "foo/foo", RepoHTMLURL: "https://github.com/foo/foo", Login: "user1", UserHTMLURL: "https://github.com/user1"},
+				{StarredAt: starredAt, RepoName: "org/bar", RepoHTMLURL: "https://github.com/org/bar", Login: "user2", UserHTMLURL: "https://github.com/user2", RepoTopics: []string{"infra"}},
+				{StarredAt: starredAt, RepoName: "org/bar", RepoHTMLURL: "https://github.com/org/bar", Login: "user3", UserHTMLURL: "https://github.com/user3", RepoTopics: []string{"infra"}},
+			},
+		},
+		{
+			name:    "unknown owner",
+			scope:   Scope{Owners: []string{"unknown"}},
+			wantErr: assert.Error,
+		},
+		{
+			name:  "rate limited",
+			scope: Scope{Owners: []string{"limited"}},
+			wantErr: func(t assert.TestingT, err error, _ ...any) bool {
+				var rateLimitErr *github.RateLimitError
+				return assert.ErrorAs(t, err, &rateLimitErr)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewGitHubGraphQLClient(Server{GraphQLURL: ts.URL + "/graphql"}, "", nil)
+			require.NoError(t, err)
+			client.RateLimiter = nil
+
+			stars, err := client.Stargazers(t.Context(), tt.scope)
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.want, stars)
+		})
+	}
+}
+
+// fakeGraphQLServer serves the queries sent by GraphQLClient.
+type fakeGraphQLServer struct{}
+
+func (f fakeGraphQLServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/graphql" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	var req struct {
+		Variables map[string]any `json:"variables"`
+		Query     string         `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var response string
+	switch {
+	case strings.Contains(req.Query, "viewer {"):
+		response = `{"data":{"viewer":{"repositories":{"pageInfo":{"hasNextPage":false},"nodes":[` +
+			repoJSON("foo/foo", false, false, "user1") + `,` + repoJSON("org/bar", false, true, "user2", "infra") + `]}}}}`
+	case strings.Contains(req.Query, "repositoryOwner(") && req.Variables["owner"] == "foo":
+		if req.Variables["cursor"] == nil {
+			response = `{"data":{"repositoryOwner":{"repositories":{"pageInfo":{"hasNextPage":true,"endCursor":"r1"},"nodes":[` +
+				repoJSON("foo/foo", false, false, "user1") + `]}}}}`
+		} else {
+			response = `{"data":{"repositoryOwner":{"repositories":{"pageInfo":{"hasNextPage":false},"nodes":[` +
+				repoJSON("foo/archived", true, false, "user2") + `]}}}}`
+		}
+	case strings.Contains(req.Query, "repositoryOwner(") && req.Variables["owner"] == "limited":
+		response = `{"errors":[{"type":"RATE_LIMITED","message":"API rate limit exceeded"}]}`
+	case strings.Contains(req.Query, "repositoryOwner("):
+		response = `{"data":{"repositoryOwner":null}}`
+	case strings.Contains(req.Query, "repository(") && req.Variables["owner"] == "org" && req.Variables["cursor"] == "s1":
+		response = `{"data":{"repository":{"stargazers":` + stargazersJSON(false, "user3") + `}}}`
+	default:
+		http.Error(w, "unexpected query", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(response))
+}
+
+func repoJSON(name string, archived bool, hasNextPage bool, login string, topics ...string) string {
+	nodes := make([]map[string]any, len(topics))
+	for i, topic := range topics {
+		nodes[i] = map[string]any{"topic": map[string]any{"name": topic}}
+	}
+	repo, _ := json.Marshal(map[string]any{"nameWithOwner": name, "url": "https://github.com/" + name, "isArchived": archived, "repositoryTopics": map[string]any{"nodes": nodes}})
+	return strings.TrimSuffix(string(repo), "}") + `,"stargazers":` + stargazersJSON(hasNextPage, login) + `}`
+}
+
+func stargazersJSON(hasNextPage bool, login string) string {
+	pageInfo := `{"hasNextPage":false}`
+	if hasNextPage {
+		pageInfo = `{"hasNextPage":true,"endCursor":"s1"}`
+	}
+	return `{"pageInfo":` + pageInfo + `,"edges":[{"starredAt":"2024-11-19T21:30:00Z","node":{"login":"` + login + `","url":"https://github.com/` + login + `"}}]}`
+}
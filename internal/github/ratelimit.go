@@ -0,0 +1,178 @@
+package github
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/clambin/github-stars/slogctx"
+	"github.com/google/go-github/v78/github"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultMaxRetries = 5
+	defaultBackoff    = time.Second
+	// secondaryRateLimitWait is the time to wait after hitting a secondary rate limit, if GitHub doesn't tell us how long to wait.
+	secondaryRateLimitWait = time.Minute
+	// rateLimitResetMargin allows for the GitHub server's clock being ahead of ours.
+	rateLimitResetMargin = time.Second
+)
+
+// RateLimiter retries GitHub API calls that fail because of rate limiting or transient server errors.
+// It also records the remaining API budget and exposes it as Prometheus metrics.
+//
+// When a call hits a rate limit, all subsequent calls made through the same RateLimiter wait until the rate limit resets.
+type RateLimiter struct {
+	blockedUntil time.Time
+	rates        map[string]github.Rate
+	// MaxRetries is the maximum number of times a failing call is retried. Default is 5.
+	MaxRetries int
+	// Backoff is the time to wait before retrying a call that failed with a server error. It doubles with each retry.
+	// Default is 1 second.
+	Backoff time.Duration
+	lock    sync.Mutex
+}
+
+// call calls f, retrying it if GitHub rate-limits the call or returns a transient server error.
+// If r is nil, f is called once.
+func call[T any](ctx context.Context, r *RateLimiter, f func() (T, *github.Response, error)) (T, *github.Response, error) {
+	if r == nil {
+		return f()
+	}
+	backoff := cmp.Or(r.Backoff, defaultBackoff)
+	for attempt := 0; ; attempt++ {
+		if err := r.waitUntilUnblocked(ctx); err != nil {
+			var zero T
+			return zero, nil, err
+		}
+		result, resp, err := f()
+		r.observe(resp)
+		if err == nil || attempt >= cmp.Or(r.MaxRetries, defaultMaxRetries) {
+			return result, resp, err
+		}
+		delay, rateLimited, ok := retryDelay(err, backoff)
+		if !ok {
+			return result, resp, err
+		}
+		slogctx.FromContext(ctx).Warn("GitHub API call failed. Retrying", "err", err, "delay", delay)
+		if rateLimited {
+			r.block(time.Now().Add(delay))
+		} else if err = sleep(ctx, delay); err != nil {
+			return result, resp, err
+		}
+		backoff *= 2
+	}
+}
+
+// retryDelay determines if a failed call should be retried and, if so, how long to wait before retrying.
+// rateLimited indicates that the call failed because of a rate limit.
+func retryDelay(err error, backoff time.Duration) (delay time.Duration, rateLimited bool, ok bool) {
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return max(time.Until(rateLimitErr.Rate.Reset.Time), 0) + rateLimitResetMargin, true, true
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		return cmp.Or(abuseErr.GetRetryAfter(), secondaryRateLimitWait), true, true
+	}
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		switch code := errResp.Response.StatusCode; {
+		case code == http.StatusTooManyRequests:
+			if seconds, err := strconv.Atoi(errResp.Response.Header.Get("Retry-After")); err == nil {
+				return time.Duration(seconds) * time.Second, true, true
+			}
+			return secondaryRateLimitWait, true, true
+		case code >= http.StatusInternalServerError:
+			return backoff, false, true
+		}
+	}
+	return 0, false, false
+}
+
+// block makes all calls wait until the provided time.
+func (r *RateLimiter) block(until time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if until.After(r.blockedUntil) {
+		r.blockedUntil = until
+	}
+}
+
+// waitUntilUnblocked waits until any rate limit has been reset, or the context is canceled.
+func (r *RateLimiter) waitUntilUnblocked(ctx context.Context) error {
+	r.lock.Lock()
+	wait := time.Until(r.blockedUntil)
+	r.lock.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	return sleep(ctx, wait)
+}
+
+// observe records the rate limit information from a GitHub response.
+func (r *RateLimiter) observe(resp *github.Response) {
+	if resp == nil || resp.Rate.Limit == 0 {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.rates == nil {
+		r.rates = make(map[string]github.Rate)
+	}
+	r.rates[cmp.Or(resp.Rate.Resource, "core")] = resp.Rate
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+var (
+	rateLimitMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("github_stars", "api", "rate_limit"),
+		"Maximum number of GitHub API calls per hour",
+		[]string{"resource"}, nil,
+	)
+	rateRemainingMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("github_stars", "api", "rate_remaining"),
+		"Number of GitHub API calls remaining in the current rate limit window",
+		[]string{"resource"}, nil,
+	)
+	rateResetMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("github_stars", "api", "rate_reset_timestamp_seconds"),
+		"Time when the current rate limit window resets",
+		[]string{"resource"}, nil,
+	)
+)
+
+var _ prometheus.Collector = (*RateLimiter)(nil)
+
+// Describe implements prometheus.Collector.
+func (r *RateLimiter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rateLimitMetric
+	ch <- rateRemainingMetric
+	ch <- rateResetMetric
+}
+
+// Collect implements prometheus.Collector.
+func (r *RateLimiter) Collect(ch chan<- prometheus.Metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for resource, rate := range r.rates {
+		ch <- prometheus.MustNewConstMetric(rateLimitMetric, prometheus.GaugeValue, float64(rate.Limit), resource)
+		ch <- prometheus.MustNewConstMetric(rateRemainingMetric, prometheus.GaugeValue, float64(rate.Remaining), resource)
+		ch <- prometheus.MustNewConstMetric(rateResetMetric, prometheus.GaugeValue, float64(rate.Reset.Unix()), resource)
+	}
+}
@@ -0,0 +1,140 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v78/github"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCall(t *testing.T) {
+	serverError := &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusBadGateway}}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   assert.ErrorAssertionFunc
+		wantCalls int
+	}{
+		{
+			name:      "success",
+			wantErr:   assert.NoError,
+			wantCalls: 1,
+		},
+		{
+			name:      "server error",
+			errs:      []error{serverError, serverError},
+			wantErr:   assert.NoError,
+			wantCalls: 3,
+		},
+		{
+			name: "primary rate limit",
+			errs: []error{&github.RateLimitError{
+				Rate:     github.Rate{Reset: github.Timestamp{Time: time.Now().Add(-time.Second)}},
+				Response: &http.Response{Request: &http.Request{Method: http.MethodGet, URL: &url.URL{}}},
+			}},
+			wantErr:   assert.NoError,
+			wantCalls: 2,
+		},
+		{
+			name: "secondary rate limit",
+			errs: []error{&github.AbuseRateLimitError{
+				RetryAfter: github.Ptr(10 * time.Millisecond),
+				Response:   &http.Response{Request: &http.Request{Method: http.MethodGet, URL: &url.URL{}}},
+			}},
+			wantErr:   assert.NoError,
+			wantCalls: 2,
+		},
+		{
+			name: "too many requests",
+			errs: []error{&github.ErrorResponse{Response: &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Header:     http.Header{"Retry-After": []string{"0"}},
+			}}},
+			wantErr:   assert.NoError,
+			wantCalls: 2,
+		},
+		{
+			name:      "not found",
+			errs:      []error{&github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}}},
+			wantErr:   assert.Error,
+			wantCalls: 1,
+		},
+		{
+			name:      "other error",
+			errs:      []error{errors.New("failed")},
+			wantErr:   assert.Error,
+			wantCalls: 1,
+		},
+		{
+			name:      "too many retries",
+			errs:      []error{serverError, serverError, serverError, serverError},
+			wantErr:   assert.Error,
+			wantCalls: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := RateLimiter{MaxRetries: 2, Backoff: time.Millisecond}
+			var calls int
+			result, _, err := call(t.Context(), &r, func() (string, *github.Response, error) {
+				calls++
+				if calls <= len(tt.errs) {
+					return "", nil, tt.errs[calls-1]
+				}
+				return "ok", &github.Response{}, nil
+			})
+			tt.wantErr(t, err)
+			assert.Equal(t, tt.wantCalls, calls)
+			if err == nil {
+				assert.Equal(t, "ok", result)
+			}
+		})
+	}
+}
+
+func TestCall_Blocked(t *testing.T) {
+	// a rate limit blocks all calls until it resets
+	var r RateLimiter
+	r.block(time.Now().Add(time.Hour))
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err := call(ctx, &r, func() (string, *github.Response, error) {
+		t.Fatal("call should not be made while the rate limiter is blocked")
+		return "", nil, nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRateLimiter_Collect(t *testing.T) {
+	var r RateLimiter
+	_, _, err := call(t.Context(), &r, func() (string, *github.Response, error) {
+		return "", &github.Response{Rate: github.Rate{
+			Limit:     5000,
+			Remaining: 4000,
+			Reset:     github.Timestamp{Time: time.Date(2025, time.November, 20, 12, 0, 0, 0, time.UTC)},
+		}}, nil
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, testutil.CollectAndCompare(&r, strings.NewReader(`
+# HELP github_stars_api_rate_limit Maximum number of GitHub API calls per hour
+# TYPE github_stars_api_rate_limit gauge
+github_stars_api_rate_limit{resource="core"} 5000
+# HELP github_stars_api_rate_remaining Number of GitHub API calls remaining in the current rate limit window
+# TYPE github_stars_api_rate_remaining gauge
+github_stars_api_rate_remaining{resource="core"} 4000
+# HELP github_stars_api_rate_reset_timestamp_seconds Time when the current rate limit window resets
+# TYPE github_stars_api_rate_reset_timestamp_seconds gauge
+github_stars_api_rate_reset_timestamp_seconds{resource="core"} 1.7636400e+09
+`)))
+}
@@ -0,0 +1,70 @@
+package github
+
+import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/google/go-github/v78/github"
+)
+
+// Server is the GitHub instance to connect to. The zero value connects to github.com.
+type Server struct {
+	// BaseURL is the URL of the REST API of a GitHub Enterprise Server, e.g. https://github.example.com/api/v3/.
+	// If the URL doesn't end in /api/v3/, it is added.
+	BaseURL string
+	// UploadURL is the URL of the upload API of a GitHub Enterprise Server. Defaults to BaseURL.
+	UploadURL string
+	// GraphQLURL is the URL of the GraphQL API. Defaults to /api/graphql on the host of BaseURL.
+	GraphQLURL string
+	// CABundle is the path to a PEM file with CA certificates to trust, in addition to the system's CA certificates.
+	CABundle string
+}
+
+// transport returns the http.RoundTripper to connect to the Server.
+func (s Server) transport() (http.RoundTripper, error) {
+	if s.CABundle == "" {
+		return http.DefaultTransport, nil
+	}
+	certs, err := os.ReadFile(s.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("ca bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(certs) {
+		return nil, errors.New("ca bundle: no certificates found")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return transport, nil
+}
+
+// client returns a go-github client for the Server that sends its requests through transport.
+func (s Server) client(transport http.RoundTripper) (*github.Client, error) {
+	client := github.NewClient(&http.Client{Transport: transport})
+	if s.BaseURL == "" {
+		return client, nil
+	}
+	return client.WithEnterpriseURLs(s.BaseURL, cmp.Or(s.UploadURL, s.BaseURL))
+}
+
+// graphQLURL returns the URL of the Server's GraphQL API. For github.com, this is relative to the REST API's URL.
+func (s Server) graphQLURL() (string, error) {
+	if s.GraphQLURL != "" || s.BaseURL == "" {
+		return cmp.Or(s.GraphQLURL, "graphql"), nil
+	}
+	u, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = "/api/graphql"
+	return u.String(), nil
+}
@@ -0,0 +1,66 @@
+package github
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Transport(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"login":"foo"}`))
+	}))
+	t.Cleanup(ts.Close)
+
+	// without the CA bundle, the server's certificate isn't trusted
+	client, err := Server{BaseURL: ts.URL}.client(http.DefaultTransport)
+	require.NoError(t, err)
+	_, _, err = client.Users.Get(t.Context(), "foo")
+	assert.Error(t, err)
+
+	// with the CA bundle, it is
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o644))
+	server := Server{BaseURL: ts.URL, CABundle: caBundle}
+	transport, err := server.transport()
+	require.NoError(t, err)
+	client, err = server.client(transport)
+	require.NoError(t, err)
+	user, _, err := client.Users.Get(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", user.GetLogin())
+	assert.Equal(t, ts.URL+"/api/v3/", client.BaseURL.String())
+
+	// invalid CA bundles
+	_, err = Server{CABundle: filepath.Join(t.TempDir(), "missing.pem")}.transport()
+	assert.Error(t, err)
+	require.NoError(t, os.WriteFile(caBundle, []byte("invalid"), 0o644))
+	_, err = Server{CABundle: caBundle}.transport()
+	assert.Error(t, err)
+}
+
+func TestServer_GraphQLURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		server Server
+		want   string
+	}{
+		{name: "github.com", want: "graphql"},
+		{name: "enterprise", server: Server{BaseURL: "https://github.example.com/api/v3/"}, want: "https://github.example.com/api/graphql"},
+		{name: "explicit", server: Server{BaseURL: "https://github.example.com/api/v3/", GraphQLURL: "https://graphql.example.com/"}, want: "https://graphql.example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.server.graphQLURL()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -99,6 +99,7 @@ func webhookHandler(handlers WebhookHandlers, secret string) http.Handler {
 				Login:       evt.Sender.GetLogin(),
 				UserHTMLURL: evt.Sender.GetHTMLURL(),
 				StarredAt:   evt.GetStarredAt().Time,
+				RepoTopics:  evt.Repo.Topics,
 			}
 			if err = handlers.StarEvent(r.Context(), stargazer); err != nil {
 				logger.Error("Unable to handle StarEvent", "err", err)
@@ -11,6 +11,7 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 
@@ -55,15 +56,16 @@ func TestNewStarEventWebhook(t *testing.T) {
 			event: github.StarEvent{
 				Action:    github.Ptr("created"),
 				StarredAt: &github.Timestamp{Time: time.Date(2025, time.November, 7, 21, 30, 0, 0, time.UTC)},
-				Repo:      &github.Repository{FullName: github.Ptr("foo/bar")},
+				Repo:      &github.Repository{FullName: github.Ptr("foo/bar"), Topics: []string{"go"}},
 				Sender:    &github.User{Login: github.Ptr("user1")},
 			},
 			secret: secret,
 			want: Stargazer{
-				Action:    "created",
-				RepoName:  "foo/bar",
-				Login:     "user1",
-				StarredAt: time.Date(2025, time.November, 7, 21, 30, 0, 0, time.UTC),
+				Action:     "created",
+				RepoName:   "foo/bar",
+				Login:      "user1",
+				StarredAt:  time.Date(2025, time.November, 7, 21, 30, 0, 0, time.UTC),
+				RepoTopics: []string{"go"},
 			},
 			wantStatusCode: http.StatusOK,
 		},
@@ -89,7 +91,7 @@ func TestNewStarEventWebhook(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			handlers := WebhookHandlers{
 				StarEvent: func(_ context.Context, stargazer Stargazer) error {
-					if stargazer != tt.want {
+					if !reflect.DeepEqual(stargazer, tt.want) {
 						return fmt.Errorf("got %v, want %v", stargazer, tt.want)
 					}
 					return nil
@@ -0,0 +1,219 @@
+package stars
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/clambin/github-stars/slogctx"
+)
+
+const (
+	// DigestFilename is the name of the file holding the state of a DigestScheduler.
+	DigestFilename = "digest.json"
+
+	digestVersion    = 1
+	digestRetryDelay = time.Minute
+)
+
+// Digest summarizes the stars that repositories gained and lost during a period.
+type Digest struct {
+	// From and To delimit the period. From is included, To is not.
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Repos are the repositories that gained or lost stars during the period, ordered by name
+	Repos []DigestRepo `json:"repos"`
+}
+
+// DigestRepo holds the stars that a repository gained and lost.
+type DigestRepo struct {
+	Repo    string `json:"repo"`
+	RepoURL string `json:"repo_url"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	// Stars is the repository's number of stars at the end of the period
+	Stars int `json:"stars"`
+}
+
+// Added returns the number of stars that all repositories gained.
+func (d Digest) Added() int {
+	var added int
+	for _, repo := range d.Repos {
+		added += repo.Added
+	}
+	return added
+}
+
+// Removed returns the number of stars that all repositories lost.
+func (d Digest) Removed() int {
+	var removed int
+	for _, repo := range d.Repos {
+		removed += repo.Removed
+	}
+	return removed
+}
+
+// newDigest returns the Digest of the period, from the Store's event history.
+func newDigest(store Store, from, to time.Time) (Digest, error) {
+	events, err := store.Events(EventFilter{Since: from, Until: to})
+	if err != nil {
+		return Digest{}, fmt.Errorf("events: %w", err)
+	}
+	repos := make(map[string]*DigestRepo)
+	for _, event := range events {
+		repo, ok := repos[event.Stargazer.RepoName]
+		if !ok {
+			repo = &DigestRepo{Repo: event.Stargazer.RepoName}
+			repos[event.Stargazer.RepoName] = repo
+		}
+		if event.Stargazer.RepoHTMLURL != "" {
+			repo.RepoURL = event.Stargazer.RepoHTMLURL
+		}
+		switch event.Type {
+		case EventStarred:
+			repo.Added++
+		case EventUnstarred:
+			repo.Removed++
+		}
+	}
+	digest := Digest{From: from, To: to, Repos: make([]DigestRepo, 0, len(repos))}
+	for _, repo := range repos {
+		stargazers, err := store.Stargazers(repo.Repo)
+		if err != nil {
+			return Digest{}, fmt.Errorf("stargazers: %w", err)
+		}
+		repo.Stars = len(stargazers)
+		digest.Repos = append(digest.Repos, *repo)
+	}
+	slices.SortFunc(digest.Repos, func(a, b DigestRepo) int { return strings.Compare(a.Repo, b.Repo) })
+	return digest, nil
+}
+
+// DigestNotifier is a Notifier that also sends digests.
+type DigestNotifier interface {
+	Notifier
+	// NotifyDigest sends the digest.
+	NotifyDigest(ctx context.Context, digest Digest) error
+}
+
+// DigestScheduler sends a Digest of the stars that repositories gained and lost since the previous digest,
+// at the times of its Schedule. Each Digest covers the period since the previous one.
+//
+// DigestScheduler saves the end of the last digest's period to disk, so a restart doesn't send a digest twice.
+// If DigestScheduler wasn't running at a scheduled time, it sends the missed digest when it starts.
+// If it missed several, a single digest covers all of them.
+type DigestScheduler struct {
+	// Store holds the event history that the digests are made from
+	Store Store
+	// Notifier sends the digests, e.g. an Outbox
+	Notifier DigestNotifier
+	// Schedule determines when to send the digests
+	Schedule Schedule
+	// Location is the time zone of the Schedule. Default is UTC.
+	Location *time.Location
+
+	path string
+	last time.Time
+}
+
+type digestFile struct {
+	Version int       `json:"version"`
+	Last    time.Time `json:"last"`
+}
+
+// NewDigestScheduler creates a DigestScheduler, loading the end of the last digest's period from path.
+// If path doesn't exist, the first digest covers the period since NewDigestScheduler was called.
+func NewDigestScheduler(store Store, notifier DigestNotifier, schedule Schedule, path string) (*DigestScheduler, error) {
+	d := DigestScheduler{Store: store, Notifier: notifier, Schedule: schedule, path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		d.last = time.Now()
+		return &d, d.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+	var f digestFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if f.Version > digestVersion {
+		return nil, fmt.Errorf("%s: %w: %d", path, errUnsupportedVersion, f.Version)
+	}
+	d.last = f.Last
+	return &d, nil
+}
+
+// Run sends the digests at the scheduled times, until the context is canceled.
+func (d *DigestScheduler) Run(ctx context.Context) {
+	logger := slogctx.FromContext(ctx)
+	for {
+		next := d.next(time.Now())
+		if next.IsZero() {
+			logger.Warn("digest schedule has no next time. no more digests will be sent")
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if err := d.send(ctx, next); err != nil {
+			logger.Error("failed to send digest", "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(digestRetryDelay):
+			}
+		}
+	}
+}
+
+// next returns the end of the next digest's period: the first scheduled time after the previous digest or,
+// if scheduled times were missed, the last scheduled time that has passed.
+func (d *DigestScheduler) next(now time.Time) time.Time {
+	loc := d.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	next := d.Schedule.Next(d.last.In(loc))
+	for !next.IsZero() {
+		after := d.Schedule.Next(next)
+		if after.IsZero() || after.After(now) {
+			break
+		}
+		next = after
+	}
+	return next
+}
+
+// send sends the digest of the period from the end of the previous digest until to.
+//
+// send saves the end of the period before notifying, so a restart doesn't send the digest again.
+// If there were no changes during the period, send doesn't send a digest.
+func (d *DigestScheduler) send(ctx context.Context, to time.Time) error {
+	digest, err := newDigest(d.Store, d.last.In(to.Location()), to)
+	if err != nil {
+		return err
+	}
+	previous := d.last
+	d.last = to
+	if err = d.save(); err != nil {
+		d.last = previous
+		return fmt.Errorf("save: %w", err)
+	}
+	if len(digest.Repos) == 0 {
+		slogctx.FromContext(ctx).Debug("no changes. skipping digest", "from", digest.From, "to", digest.To)
+		return nil
+	}
+	return d.Notifier.NotifyDigest(ctx, digest)
+}
+
+func (d *DigestScheduler) save() error {
+	return writeJSONFile(d.path, digestFile{Version: digestVersion, Last: d.last})
+}
@@ -0,0 +1,89 @@
+package stars
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDigest(t *testing.T) {
+	store := newDigestTestStore(t)
+	digest, err := newDigest(store, time.Date(2025, time.March, 3, 0, 0, 0, 0, time.UTC), time.Date(2025, time.March, 4, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, []DigestRepo{
+		{Repo: "foo/bar", RepoURL: "https://example.com/foo/bar", Added: 2, Removed: 1, Stars: 1},
+		{Repo: "foo/baz", Added: 1, Stars: 1},
+	}, digest.Repos)
+	assert.Equal(t, 3, digest.Added())
+	assert.Equal(t, 1, digest.Removed())
+}
+
+func TestDigestScheduler(t *testing.T) {
+	store := newDigestTestStore(t)
+	var n fakeNotifier
+	schedule, err := ParseSchedule("0 9 * * *")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), DigestFilename)
+	require.NoError(t, writeJSONFile(path, digestFile{Version: digestVersion, Last: time.Date(2025, time.March, 1, 9, 0, 0, 0, time.UTC)}))
+
+	d, err := NewDigestScheduler(store, &n, schedule, path)
+	require.NoError(t, err)
+
+	// missed digests are combined into one
+	now := time.Date(2025, time.March, 4, 10, 0, 0, 0, time.UTC)
+	next := d.next(now)
+	assert.Equal(t, time.Date(2025, time.March, 4, 9, 0, 0, 0, time.UTC), next)
+	require.NoError(t, d.send(t.Context(), next))
+	digests := n.digests()
+	require.Len(t, digests, 1)
+	assert.Equal(t, time.Date(2025, time.March, 1, 9, 0, 0, 0, time.UTC), digests[0].From)
+	assert.Len(t, digests[0].Repos, 2)
+
+	// after a restart, the digest is not sent again
+	d, err = NewDigestScheduler(store, &n, schedule, path)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, time.March, 5, 9, 0, 0, 0, time.UTC), d.next(now))
+
+	// no changes: no digest
+	require.NoError(t, d.send(t.Context(), d.next(now)))
+	assert.Len(t, n.digests(), 1)
+}
+
+func TestNewDigestScheduler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DigestFilename)
+	start := time.Now()
+	d, err := NewDigestScheduler(nil, nil, Schedule{}, path)
+	require.NoError(t, err)
+	assert.False(t, d.last.Before(start))
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":2}`), 0600))
+	_, err = NewDigestScheduler(nil, nil, Schedule{}, path)
+	assert.ErrorIs(t, err, errUnsupportedVersion)
+}
+
+// newDigestTestStore returns a Store with events on March 3rd, 2025, and one on March 2nd.
+func newDigestTestStore(t *testing.T) Store {
+	t.Helper()
+	store, err := NewJSONStore(t.TempDir())
+	require.NoError(t, err)
+	user1 := github.Stargazer{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user1"}
+	user2 := github.Stargazer{RepoName: "foo/bar", Login: "user2"}
+	user3 := github.Stargazer{RepoName: "foo/baz", Login: "user3"}
+	_, err = store.Add(user1, user3)
+	require.NoError(t, err)
+	require.NoError(t, store.AddEvents(
+		Event{Time: time.Date(2025, time.March, 2, 12, 0, 0, 0, time.UTC), Type: EventStarred, Stargazer: user3},
+		Event{Time: time.Date(2025, time.March, 3, 12, 0, 0, 0, time.UTC), Type: EventStarred, Stargazer: user1},
+		Event{Time: time.Date(2025, time.March, 3, 13, 0, 0, 0, time.UTC), Type: EventStarred, Stargazer: user2},
+		Event{Time: time.Date(2025, time.March, 3, 14, 0, 0, 0, time.UTC), Type: EventUnstarred, Stargazer: user2},
+		Event{Time: time.Date(2025, time.March, 3, 15, 0, 0, 0, time.UTC), Type: EventStarred, Stargazer: user3},
+	))
+	return store
+}
@@ -0,0 +1,68 @@
+package stars
+
+import (
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+)
+
+// EventType is the type of change recorded by an Event.
+type EventType string
+
+const (
+	EventStarred   EventType = "starred"
+	EventUnstarred EventType = "unstarred"
+)
+
+// EventSource is how a change was detected.
+type EventSource string
+
+const (
+	// SourceWebhook means the change was received through a GitHub webhook event.
+	SourceWebhook EventSource = "webhook"
+	// SourceScan means the change was found by scanning the repositories.
+	SourceScan EventSource = "scan"
+)
+
+// Event records a stargazer starring or unstarring a repository. A Store keeps all Events, so the history
+// of a repository's stargazers can be queried and replayed, including stargazers that have since removed their star.
+type Event struct {
+	// Time is when the change was detected. For EventStarred, Stargazer.StarredAt holds the time reported by GitHub.
+	Time      time.Time        `json:"time"`
+	Type      EventType        `json:"type"`
+	Source    EventSource      `json:"source"`
+	Stargazer github.Stargazer `json:"stargazer"`
+}
+
+// EventFilter selects the Events returned by Store.Events. Blank fields match all Events.
+type EventFilter struct {
+	// Since selects the Events at or after Since.
+	Since time.Time
+	// Until selects the Events before Until.
+	Until  time.Time
+	Repo   string
+	Login  string
+	Type   EventType
+	Source EventSource
+}
+
+// match reports whether the Event matches the filter.
+func (f EventFilter) match(e Event) bool {
+	return (f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until)) &&
+		(f.Repo == "" || e.Stargazer.RepoName == f.Repo) &&
+		(f.Login == "" || e.Stargazer.Login == f.Login) &&
+		(f.Type == "" || e.Type == f.Type) &&
+		(f.Source == "" || e.Source == f.Source)
+}
+
+// newEvents returns an Event of the requested type and source for each stargazer.
+func newEvents(eventType EventType, source EventSource, stargazers []github.Stargazer) []Event {
+	now := time.Now()
+	events := make([]Event, len(stargazers))
+	for i, star := range stargazers {
+		star.Action = ""
+		events[i] = Event{Time: now, Type: eventType, Source: source, Stargazer: star}
+	}
+	return events
+}
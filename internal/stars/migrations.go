@@ -0,0 +1,144 @@
+package stars
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/clambin/github-stars/internal/github"
+)
+
+// errUnsupportedVersion means the database was written by a newer version of github-stars.
+var errUnsupportedVersion = errors.New("unsupported database version")
+
+// jsonVersion is the current version of the JSONStore's database format.
+const jsonVersion = 2
+
+// jsonDatabase is the database format of a JSONStore.
+type jsonDatabase struct {
+	Version    int                `json:"version"`
+	Stargazers []github.Stargazer `json:"stargazers"`
+}
+
+// jsonMigrations upgrade a JSONStore's database from one version to the next: jsonMigrations[i] upgrades
+// version i+1 to version i+2. To change the database format, increase jsonVersion and add a migration.
+var jsonMigrations = []func([]byte) ([]byte, error){
+	// version 1 is a bare array of stargazers.
+	func(data []byte) ([]byte, error) {
+		return json.Marshal(struct {
+			Version    int             `json:"version"`
+			Stargazers json.RawMessage `json:"stargazers"`
+		}{Version: 2, Stargazers: data})
+	},
+}
+
+// migrateJSON upgrades a JSONStore database to the current version. It returns the upgraded database and
+// the version of the original database.
+func migrateJSON(data []byte) ([]byte, int, error) {
+	version, err := jsonDatabaseVersion(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > jsonVersion {
+		return nil, version, fmt.Errorf("%w: %d", errUnsupportedVersion, version)
+	}
+	for v := version; v < jsonVersion; v++ {
+		if data, err = jsonMigrations[v-1](data); err != nil {
+			return nil, version, fmt.Errorf("migrate to version %d: %w", v+1, err)
+		}
+	}
+	return data, version, nil
+}
+
+// jsonDatabaseVersion returns the version of a JSONStore database.
+func jsonDatabaseVersion(data []byte) (int, error) {
+	// version 1 saves an empty store as null
+	if data = bytes.TrimSpace(data); bytes.Equal(data, []byte("null")) || len(data) > 0 && data[0] == '[' {
+		return 1, nil
+	}
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, fmt.Errorf("decode: %w", err)
+	}
+	if header.Version < 1 {
+		return 0, errors.New("missing database version")
+	}
+	return header.Version, nil
+}
+
+// sqliteMigrations upgrade a SQLiteStore's database from one version to the next: sqliteMigrations[i] upgrades
+// version i to version i+1. The database's version is stored in its user_version. To change the database schema,
+// add a migration.
+var sqliteMigrations = []string{
+	// version 0 is an empty database, or one created before the schema was versioned.
+	`
+CREATE TABLE IF NOT EXISTS stargazers (
+	repo_name     TEXT NOT NULL,
+	login         TEXT NOT NULL,
+	repo_html_url TEXT NOT NULL DEFAULT '',
+	user_html_url TEXT NOT NULL DEFAULT '',
+	starred_at    TEXT NOT NULL,
+	PRIMARY KEY (repo_name, login)
+);
+CREATE TABLE IF NOT EXISTS events (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	time          TEXT NOT NULL,
+	type          TEXT NOT NULL,
+	source        TEXT NOT NULL,
+	repo_name     TEXT NOT NULL,
+	login         TEXT NOT NULL,
+	repo_html_url TEXT NOT NULL DEFAULT '',
+	user_html_url TEXT NOT NULL DEFAULT '',
+	starred_at    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_repo_name_time ON events (repo_name, time)`,
+}
+
+// migrateSQLite upgrades a SQLiteStore database to the current version. Before upgrading an existing database,
+// migrateSQLite saves a copy of it as <path>.v<version>.
+func migrateSQLite(db *sql.DB, path string) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("version: %w", err)
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("%w: %d", errUnsupportedVersion, version)
+	}
+	if version == len(sqliteMigrations) {
+		return nil
+	}
+
+	var tables int
+	if err := db.QueryRow(`SELECT count(*) FROM sqlite_master`).Scan(&tables); err != nil {
+		return fmt.Errorf("tables: %w", err)
+	}
+	if tables > 0 {
+		backup := path + ".v" + strconv.Itoa(version)
+		if err := os.Remove(backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("backup: %w", err)
+		}
+		if _, err := db.Exec(`VACUUM INTO ?`, backup); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+	}
+
+	for v := version; v < len(sqliteMigrations); v++ {
+		err := inTx(db, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(sqliteMigrations[v]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`PRAGMA user_version = ` + strconv.Itoa(v+1))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migrate to version %d: %w", v+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package stars
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewJSONStore_Migrate(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, StoreFilename)
+	const v1 = `[{"starred_at":"2024-11-20T08:00:00Z","repo_name":"foo/bar","repo_html_url":"","login":"user1","user_html_url":""}]`
+	require.NoError(t, os.WriteFile(path, []byte(v1), 0o644))
+
+	store, err := NewJSONStore(tmpDir)
+	require.NoError(t, err)
+	stargazers, err := store.Stargazers("")
+	require.NoError(t, err)
+	assert.Equal(t, []github.Stargazer{{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "foo/bar", Login: "user1"}}, stargazers)
+
+	// the database is upgraded in place
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	version, err := jsonDatabaseVersion(data)
+	require.NoError(t, err)
+	assert.Equal(t, jsonVersion, version)
+
+	// the original database is kept
+	data, err = os.ReadFile(path + ".v1")
+	require.NoError(t, err)
+	assert.Equal(t, v1, string(data))
+}
+
+func TestNewJSONStore_Migrate_Empty(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, StoreFilename)
+	// version 1 of an empty store
+	require.NoError(t, os.WriteFile(path, []byte("null"), 0o644))
+
+	store, err := NewJSONStore(tmpDir)
+	require.NoError(t, err)
+	stargazers, err := store.Stargazers("")
+	require.NoError(t, err)
+	assert.Empty(t, stargazers)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	version, err := jsonDatabaseVersion(data)
+	require.NoError(t, err)
+	assert.Equal(t, jsonVersion, version)
+}
+
+func TestNewJSONStore_Migrate_Newer(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, StoreFilename)
+	const newer = `{"version": 999, "stargazers": []}`
+	require.NoError(t, os.WriteFile(path, []byte(newer), 0o644))
+
+	_, err := NewJSONStore(tmpDir)
+	require.ErrorIs(t, err, errUnsupportedVersion)
+
+	// the database is left untouched
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, newer, string(data))
+}
+
+func TestNewSQLiteStore_Migrate(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, SQLiteFilename)
+
+	// create a database without a version, as created before the schema was versioned
+	db, err := sql.Open("sqlite", "file:"+path)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE stargazers (
+	repo_name     TEXT NOT NULL,
+	login         TEXT NOT NULL,
+	repo_html_url TEXT NOT NULL DEFAULT '',
+	user_html_url TEXT NOT NULL DEFAULT '',
+	starred_at    TEXT NOT NULL,
+	PRIMARY KEY (repo_name, login)
+);
+INSERT INTO stargazers (repo_name, login, starred_at) VALUES ('foo/bar', 'user1', '2024-11-20T08:00:00Z')`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	store, err := NewSQLiteStore(tmpDir)
+	require.NoError(t, err)
+	stargazers, err := store.Stargazers("")
+	require.NoError(t, err)
+	assert.Equal(t, []github.Stargazer{{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "foo/bar", Login: "user1"}}, stargazers)
+	events, err := store.Events(EventFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	var version int
+	require.NoError(t, store.db.QueryRow(`PRAGMA user_version`).Scan(&version))
+	assert.Equal(t, len(sqliteMigrations), version)
+	require.NoError(t, store.Close())
+	assert.FileExists(t, path+".v0")
+
+	// a database written by a newer version is rejected
+	db, err = sql.Open("sqlite", "file:"+path)
+	require.NoError(t, err)
+	_, err = db.Exec(`PRAGMA user_version = 999`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	_, err = NewSQLiteStore(tmpDir)
+	assert.ErrorIs(t, err, errUnsupportedVersion)
+}
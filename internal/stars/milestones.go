@@ -0,0 +1,164 @@
+package stars
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/clambin/github-stars/internal/github"
+)
+
+const (
+	// MilestonesFilename is the name of the file holding the milestones that each repository reached.
+	MilestonesFilename = "milestones.json"
+
+	milestonesVersion = 1
+)
+
+// MilestoneNotifier is a Notifier that also notifies when a repository reaches a milestone.
+type MilestoneNotifier interface {
+	Notifier
+	// NotifyMilestone notifies that the repository reached the number of stars in milestone.
+	// repo holds the repository's details: only its RepoName, RepoHTMLURL and RepoTopics are set.
+	NotifyMilestone(ctx context.Context, repo github.Stargazer, milestone int) error
+}
+
+// Milestones are the numbers of stars that are worth a notification.
+type Milestones struct {
+	// Counts are the milestones, e.g. 10, 50 and 100
+	Counts []int
+	// Every makes each multiple of Every a milestone, e.g. 1000, 2000, 3000. Ignored if zero.
+	Every int
+	// PowersOf makes each power of PowersOf a milestone, e.g. 2, 4, 8, 16 for PowersOf 2. Ignored if less than 2.
+	PowersOf int
+}
+
+// ParseMilestones parses a comma-separated list of milestones. Each item is either a number of stars,
+// "every:N" for each multiple of N, or "powers:N" for each power of N, e.g. "10,50,100,every:1000".
+func ParseMilestones(spec string) (Milestones, error) {
+	var m Milestones
+	for item := range strings.SplitSeq(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, ":")
+		if !ok {
+			key, value = "", item
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n <= 0 {
+			return Milestones{}, fmt.Errorf("invalid milestone: %q", item)
+		}
+		switch strings.TrimSpace(key) {
+		case "":
+			m.Counts = append(m.Counts, n)
+		case "every":
+			m.Every = n
+		case "powers":
+			if n < 2 {
+				return Milestones{}, fmt.Errorf("invalid milestone: %q", item)
+			}
+			m.PowersOf = n
+		default:
+			return Milestones{}, fmt.Errorf("invalid milestone: %q", item)
+		}
+	}
+	slices.Sort(m.Counts)
+	return m, nil
+}
+
+// highest returns the highest milestone that a repository with count stars reached, or 0 if it reached none.
+func (m Milestones) highest(count int) int {
+	var highest int
+	for _, c := range m.Counts {
+		if c <= count {
+			highest = max(highest, c)
+		}
+	}
+	if m.Every > 0 {
+		highest = max(highest, count/m.Every*m.Every)
+	}
+	if m.PowersOf >= 2 {
+		for p := m.PowersOf; p <= count; p *= m.PowersOf {
+			highest = max(highest, p)
+			if p > math.MaxInt/m.PowersOf {
+				break
+			}
+		}
+	}
+	return highest
+}
+
+// MilestoneTracker records the highest milestone that each repository reached, so a repository whose number of stars
+// goes up and down around a milestone only reaches it once. MilestoneTracker saves the milestones to disk,
+// so they survive a restart.
+type MilestoneTracker struct {
+	milestones Milestones
+	reached    map[string]int
+	path       string
+	lock       sync.Mutex
+}
+
+type milestonesFile struct {
+	Version int            `json:"version"`
+	Reached map[string]int `json:"reached"`
+}
+
+// NewMilestoneTracker creates a MilestoneTracker for the milestones, loading the milestones reached so far from path.
+func NewMilestoneTracker(milestones Milestones, path string) (*MilestoneTracker, error) {
+	t := MilestoneTracker{milestones: milestones, reached: make(map[string]int), path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+	var f milestonesFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if f.Version > milestonesVersion {
+		return nil, fmt.Errorf("%s: %w: %d", path, errUnsupportedVersion, f.Version)
+	}
+	if f.Reached != nil {
+		t.reached = f.Reached
+	}
+	return &t, nil
+}
+
+// update records that a repository's number of stars went from before to after. It returns the milestone that
+// the repository reached, or 0 if it didn't reach a new milestone.
+//
+// If the repository is not yet tracked, a scan may have found stars that it gained long ago (e.g. on the first scan,
+// or for a repository that was just added to the scan): update starts tracking the repository, assuming it already
+// reached the milestones up to after. A webhook event is a star that the repository just gained: update assumes
+// it already reached the milestones up to before.
+func (t *MilestoneTracker) update(repo string, before, after int, source EventSource) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	reached, ok := t.reached[repo]
+	if !ok {
+		if source == SourceScan {
+			before = after
+		}
+		reached = t.milestones.highest(before)
+	}
+	milestone := t.milestones.highest(after)
+	if milestone <= reached {
+		milestone = 0
+	}
+	if ok && milestone == 0 {
+		return 0, nil
+	}
+	t.reached[repo] = max(reached, milestone)
+	return milestone, writeJSONFile(t.path, milestonesFile{Version: milestonesVersion, Reached: t.reached})
+}
@@ -0,0 +1,105 @@
+package stars
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMilestones(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    Milestones
+		wantErr bool
+	}{
+		{name: "empty", spec: ""},
+		{name: "counts", spec: "100, 10,50", want: Milestones{Counts: []int{10, 50, 100}}},
+		{name: "every", spec: "10,50,100,every:1000", want: Milestones{Counts: []int{10, 50, 100}, Every: 1000}},
+		{name: "powers", spec: "powers:2", want: Milestones{PowersOf: 2}},
+		{name: "invalid count", spec: "ten", wantErr: true},
+		{name: "negative count", spec: "-10", wantErr: true},
+		{name: "invalid every", spec: "every:", wantErr: true},
+		{name: "invalid powers", spec: "powers:1", wantErr: true},
+		{name: "unknown", spec: "each:10", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMilestones(tt.spec)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, m)
+		})
+	}
+}
+
+func TestMilestones_highest(t *testing.T) {
+	m := Milestones{Counts: []int{10, 50, 100}, Every: 1000}
+	for count, want := range map[int]int{0: 0, 9: 0, 10: 10, 99: 50, 100: 100, 999: 100, 1000: 1000, 4321: 4000} {
+		assert.Equal(t, want, m.highest(count), count)
+	}
+	m = Milestones{PowersOf: 2}
+	for count, want := range map[int]int{1: 0, 2: 2, 3: 2, 1000: 512, 1024: 1024} {
+		assert.Equal(t, want, m.highest(count), count)
+	}
+}
+
+func TestMilestoneTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), MilestonesFilename)
+	tracker, err := NewMilestoneTracker(Milestones{Counts: []int{1, 10, 50, 100}}, path)
+	require.NoError(t, err)
+
+	for _, step := range []struct {
+		before, after, want int
+	}{
+		{before: 8, after: 9, want: 0},
+		{before: 9, after: 12, want: 10},
+		// oscillating around a milestone doesn't reach it again
+		{before: 12, after: 9, want: 0},
+		{before: 9, after: 10, want: 0},
+		// only the highest milestone is reached
+		{before: 10, after: 120, want: 100},
+	} {
+		milestone, err := tracker.update("foo/bar", step.before, step.after, SourceWebhook)
+		require.NoError(t, err)
+		assert.Equal(t, step.want, milestone, step)
+	}
+
+	// a new repository already reached the milestones below its number of stars
+	milestone, err := tracker.update("foo/baz", 60, 59, SourceWebhook)
+	require.NoError(t, err)
+	assert.Zero(t, milestone)
+
+	// a scan doesn't reach the milestones of the stars that a new repository already had
+	milestone, err = tracker.update("foo/new", 0, 4000, SourceScan)
+	require.NoError(t, err)
+	assert.Zero(t, milestone)
+	milestone, err = tracker.update("foo/new", 4000, 4001, SourceScan)
+	require.NoError(t, err)
+	assert.Zero(t, milestone)
+
+	// a new repository's first star reaches the first milestone
+	milestone, err = tracker.update("foo/first", 0, 1, SourceWebhook)
+	require.NoError(t, err)
+	assert.Equal(t, 1, milestone)
+
+	// the milestones survive a restart
+	tracker, err = NewMilestoneTracker(Milestones{Counts: []int{1, 10, 50, 100}}, path)
+	require.NoError(t, err)
+	milestone, err = tracker.update("foo/bar", 99, 100, SourceWebhook)
+	require.NoError(t, err)
+	assert.Zero(t, milestone)
+	milestone, err = tracker.update("foo/baz", 49, 50, SourceWebhook)
+	require.NoError(t, err)
+	assert.Zero(t, milestone)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":2}`), 0600))
+	_, err = NewMilestoneTracker(Milestones{}, path)
+	assert.ErrorIs(t, err, errUnsupportedVersion)
+}
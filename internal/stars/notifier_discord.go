@@ -0,0 +1,178 @@
+package stars
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+)
+
+const (
+	// discordMaxEmbeds is the maximum number of embeds in a Discord message.
+	discordMaxEmbeds = 10
+
+	discordColorAdded     = 0x2ea44f
+	discordColorRemoved   = 0xcb2431
+	discordColorMilestone = 0xe3b341
+	discordColorDigest    = 0x0969da
+)
+
+// DiscordNotifier is a Notifier that posts added/removed stargazers to a Discord channel, using a Discord webhook.
+// Each message shows one embed per stargazer, with the stargazer's avatar and a link to their profile.
+type DiscordNotifier struct {
+	// Templates renders the description of the messages. If nil, DiscordNotifier posts the default descriptions.
+	Templates *Templates
+	// WebHookURL is the URL to the Discord webhook
+	WebHookURL string
+	// Store holds the stargazers of each repository. If set, messages show the repository's number of stargazers.
+	Store Store
+	// HTTPClient is the client to post the messages with. Default is http.DefaultClient.
+	HTTPClient *http.Client
+	// MaximumUsers is the maximum number of users to notify about. Default is 5, maximum is 10.
+	// If the number of stargazers is greater than this, DiscordNotifier only notifies the number of users.
+	MaximumUsers int
+}
+
+var (
+	_ MilestoneNotifier = DiscordNotifier{}
+	_ DigestNotifier    = DiscordNotifier{}
+)
+
+func (d DiscordNotifier) Notify(ctx context.Context, added bool, stars []github.Stargazer) error {
+	var errs []error
+	for _, stargazers := range stargazersByRepo(stars) {
+		msg, err := d.makeMessage(stargazers, added)
+		if err == nil {
+			err = postJSON(ctx, d.HTTPClient, d.WebHookURL, msg)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d DiscordNotifier) NotifyMilestone(ctx context.Context, repo github.Stargazer, milestone int) error {
+	description, err := d.Templates.render("discord", MessageMilestone, markupMarkdown, newMilestoneData(repo, milestone))
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, d.HTTPClient, d.WebHookURL, discordMessage{Embeds: []discordEmbed{{
+		Title:       repo.RepoName,
+		URL:         repo.RepoHTMLURL,
+		Description: description,
+		Color:       discordColorMilestone,
+	}}})
+}
+
+func (d DiscordNotifier) NotifyDigest(ctx context.Context, digest Digest) error {
+	description, err := d.Templates.render("discord", MessageDigest, markupMarkdown, newDigestData(digest))
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, d.HTTPClient, d.WebHookURL, discordMessage{Embeds: []discordEmbed{{
+		Title:       "GitHub stars",
+		Description: description,
+		Color:       discordColorDigest,
+	}}})
+}
+
+type discordMessage struct {
+	Embeds []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Author      *discordAuthor    `json:"author,omitempty"`
+	Thumbnail   *discordThumbnail `json:"thumbnail,omitempty"`
+	Footer      *discordFooter    `json:"footer,omitempty"`
+	Title       string            `json:"title"`
+	URL         string            `json:"url,omitempty"`
+	Description string            `json:"description"`
+	Timestamp   string            `json:"timestamp,omitempty"`
+	Color       int               `json:"color"`
+}
+
+type discordAuthor struct {
+	Name    string `json:"name"`
+	URL     string `json:"url,omitempty"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type discordThumbnail struct {
+	URL string `json:"url"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+func (d DiscordNotifier) makeMessage(gazers []github.Stargazer, added bool) (discordMessage, error) {
+	if len(gazers) == 0 {
+		return discordMessage{}, nil
+	}
+	color := discordColorRemoved
+	if added {
+		color = discordColorAdded
+	}
+	repo := gazers[0]
+	footer := d.starCount(repo.RepoName)
+
+	maxUsers := min(cmp.Or(d.MaximumUsers, defaultMaximumUsers), discordMaxEmbeds)
+	if len(gazers) > maxUsers {
+		description, err := d.Templates.render("discord", messageKind(added), markupMarkdown, newMessageData(gazers, added, maxUsers))
+		if err != nil {
+			return discordMessage{}, err
+		}
+		return discordMessage{Embeds: []discordEmbed{{
+			Title:       repo.RepoName,
+			URL:         repo.RepoHTMLURL,
+			Description: description,
+			Color:       color,
+			Footer:      footer,
+		}}}, nil
+	}
+
+	embeds := make([]discordEmbed, len(gazers))
+	for i, gazer := range gazers {
+		description, err := d.Templates.render("discord", messageKind(added), markupMarkdown, newMessageData([]github.Stargazer{gazer}, added, maxUsers))
+		if err != nil {
+			return discordMessage{}, err
+		}
+		embeds[i] = discordEmbed{
+			Author:      &discordAuthor{Name: gazer.Login, URL: gazer.UserHTMLURL, IconURL: avatarURL(gazer)},
+			Thumbnail:   &discordThumbnail{URL: avatarURL(gazer)},
+			Footer:      footer,
+			Title:       repo.RepoName,
+			URL:         repo.RepoHTMLURL,
+			Description: description,
+			Color:       color,
+		}
+		if added && !gazer.StarredAt.IsZero() {
+			embeds[i].Timestamp = gazer.StarredAt.UTC().Format(time.RFC3339)
+		}
+	}
+	return discordMessage{Embeds: embeds}, nil
+}
+
+// starCount returns a footer with the repository's current number of stargazers, or nil if the number is not known.
+func (d DiscordNotifier) starCount(repo string) *discordFooter {
+	count, ok := stargazerCount(d.Store, repo)
+	if !ok {
+		return nil
+	}
+	return &discordFooter{Text: strconv.Itoa(count) + " " + plural(count, "star", "stars")}
+}
+
+// avatarURL returns the URL of the stargazer's avatar. Both github.com and GitHub Enterprise Server serve
+// a user's avatar at their profile URL, followed by ".png".
+func avatarURL(stargazer github.Stargazer) string {
+	if stargazer.UserHTMLURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(stargazer.UserHTMLURL, "/") + ".png"
+}
@@ -0,0 +1,116 @@
+package stars
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscordNotifier(t *testing.T) {
+	var d fakeDiscordWebhook
+	ts := httptest.NewServer(&d)
+	t.Cleanup(ts.Close)
+
+	store, err := NewJSONStore(t.TempDir())
+	require.NoError(t, err)
+	gazer := github.Stargazer{
+		StarredAt:   time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC),
+		RepoName:    "foo/bar",
+		RepoHTMLURL: "https://example.com/foo/bar",
+		Login:       "user1",
+		UserHTMLURL: "https://example.com/user1",
+	}
+	_, err = store.Add(gazer)
+	require.NoError(t, err)
+
+	n := DiscordNotifier{WebHookURL: ts.URL, Store: store}
+	assert.NoError(t, n.Notify(t.Context(), true, []github.Stargazer{gazer}))
+
+	want := discordMessage{Embeds: []discordEmbed{{
+		Author:      &discordAuthor{Name: "user1", URL: "https://example.com/user1", IconURL: "https://example.com/user1.png"},
+		Thumbnail:   &discordThumbnail{URL: "https://example.com/user1.png"},
+		Footer:      &discordFooter{Text: "1 star"},
+		Title:       "foo/bar",
+		URL:         "https://example.com/foo/bar",
+		Description: "Repo received a star from [@user1](https://example.com/user1)",
+		Timestamp:   "2024-11-20T08:00:00Z",
+		Color:       discordColorAdded,
+	}}}
+	assert.Equal(t, []discordMessage{want}, d.received())
+}
+
+func TestDiscordNotifier_makeMessage(t *testing.T) {
+	gazers := make([]github.Stargazer, 6)
+	for i := range gazers {
+		gazers[i] = github.Stargazer{RepoName: "foo/bar", Login: "user" + strconv.Itoa(i)}
+	}
+
+	tests := []struct {
+		name         string
+		maximumUsers int
+		count        int
+		added        bool
+		want         []string
+	}{
+		{name: "one user", count: 1, added: true, want: []string{"Repo received a star from user0"}},
+		{name: "removed", count: 1, want: []string{"Repo lost a star from user0"}},
+		{name: "max users", count: 5, added: true, want: []string{
+			"Repo received a star from user0",
+			"Repo received a star from user1",
+			"Repo received a star from user2",
+			"Repo received a star from user3",
+			"Repo received a star from user4",
+		}},
+		{name: "too many users", count: 6, added: true, want: []string{"Repo received a star from 6 users"}},
+		{name: "custom max users", maximumUsers: 1, count: 2, added: true, want: []string{"Repo received a star from 2 users"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := DiscordNotifier{MaximumUsers: tt.maximumUsers}.makeMessage(gazers[:tt.count], tt.added)
+			require.NoError(t, err)
+			var got []string
+			for _, embed := range msg.Embeds {
+				got = append(got, embed.Description)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+type fakeDiscordWebhook struct {
+	messages []discordMessage
+	lock     sync.Mutex
+}
+
+var _ http.Handler = (*fakeDiscordWebhook)(nil)
+
+func (f *fakeDiscordWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	var msg discordMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.messages = append(f.messages, msg)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeDiscordWebhook) received() []discordMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.messages
+}
@@ -0,0 +1,442 @@
+package stars
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/clambin/github-stars/slogctx"
+)
+
+const (
+	// EmailDigestFilename is the name of the file holding the changes that wait for the next email digest.
+	EmailDigestFilename = "email-digest.json"
+
+	emailDigestVersion = 1
+)
+
+// EmailSecurity determines how EmailNotifier secures its connection to the SMTP server.
+type EmailSecurity string
+
+const (
+	// EmailSecuritySTARTTLS connects in plain text and upgrades the connection with STARTTLS. Default port is 587.
+	EmailSecuritySTARTTLS EmailSecurity = "starttls"
+	// EmailSecurityTLS connects over TLS (implicit TLS). Default port is 465.
+	EmailSecurityTLS EmailSecurity = "tls"
+	// EmailSecurityNone doesn't secure the connection. Default port is 25.
+	EmailSecurityNone EmailSecurity = "none"
+)
+
+// EmailNotifier is a Notifier that emails added/removed stargazers over SMTP. Each email has a plain text
+// and an HTML version.
+//
+// By default, EmailNotifier sends one email per repository whenever stargazers are added or removed.
+// If DigestInterval is set, it collects all changes and Run sends them as a single digest every DigestInterval.
+// The collected changes are saved to DigestPath, so they aren't lost if github-stars stops before sending the digest.
+//
+// DigestInterval only batches EmailNotifier's own emails. It is unrelated to the Digest that a DigestScheduler sends
+// to all DigestNotifiers: EmailNotifier emails such a Digest right away, as a separate email.
+type EmailNotifier struct {
+	// Host is the SMTP server's hostname
+	Host string
+	// Port is the SMTP server's port. Default depends on Security.
+	Port int
+	// Security determines how to secure the connection to the SMTP server. Default is EmailSecuritySTARTTLS.
+	Security EmailSecurity
+	// Username and Password authenticate with the SMTP server. If Username is blank, EmailNotifier does not authenticate.
+	Username string
+	Password string
+	// From is the sender's email address
+	From string
+	// To are the recipients' email addresses
+	To []string
+	// DigestInterval is the time between two emails that batch the changes. If zero, EmailNotifier sends an email for every change.
+	DigestInterval time.Duration
+	// DigestPath is the file holding the changes that wait for the next digest. If blank, they are only kept in memory.
+	DigestPath string
+	// TLSConfig is the TLS configuration to connect to the SMTP server. If nil, EmailNotifier uses the default configuration.
+	TLSConfig *tls.Config
+	// Templates renders the emails: the body with the "email" templates, in plain text and HTML, and the subject
+	// with the "email.subject" templates. If nil, EmailNotifier sends the default messages.
+	Templates *Templates
+
+	pending []emailChange
+	// loaded is true once the pending changes were loaded from DigestPath
+	loaded bool
+	lock   sync.Mutex
+}
+
+// emailChange is a list of stargazers that were added to, or removed from, a repository.
+// For a milestone, Stargazers holds the repository's details.
+type emailChange struct {
+	Stargazers []github.Stargazer `json:"stargazers"`
+	Milestone  int                `json:"milestone,omitempty"`
+	Added      bool               `json:"added"`
+	// Time is when the change was added to the digest
+	Time time.Time `json:"time"`
+}
+
+type emailDigestFile struct {
+	Version int           `json:"version"`
+	Changes []emailChange `json:"changes"`
+}
+
+var (
+	_ MilestoneNotifier = (*EmailNotifier)(nil)
+	_ DigestNotifier    = (*EmailNotifier)(nil)
+)
+
+func (e *EmailNotifier) Notify(ctx context.Context, added bool, stars []github.Stargazer) error {
+	var errs []error
+	for _, stargazers := range stargazersByRepo(stars) {
+		if err := e.notify(ctx, emailChange{Stargazers: stargazers, Added: added}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *EmailNotifier) NotifyMilestone(ctx context.Context, repo github.Stargazer, milestone int) error {
+	return e.notify(ctx, emailChange{Stargazers: []github.Stargazer{repo}, Milestone: milestone, Added: true})
+}
+
+// NotifyDigest emails the digest. Unlike changes, a digest is always sent right away, regardless of DigestInterval.
+func (e *EmailNotifier) NotifyDigest(ctx context.Context, digest Digest) error {
+	data := newDigestData(digest)
+	subject, err := e.subject(MessageDigest, data)
+	if err != nil {
+		return err
+	}
+	textBody, htmlBody, err := e.body(emailMessage{kind: MessageDigest, data: data})
+	if err != nil {
+		return err
+	}
+	return e.send(ctx, subject, textBody, htmlBody)
+}
+
+// notify emails the change, or adds it to the next digest.
+func (e *EmailNotifier) notify(ctx context.Context, change emailChange) error {
+	if e.DigestInterval > 0 {
+		change.Time = time.Now()
+		if err := e.addPending(change); err != nil {
+			return fmt.Errorf("digest: %w", err)
+		}
+		return nil
+	}
+	msg := change.message()
+	subject, err := e.subject(msg.kind, msg.data)
+	if err != nil {
+		return err
+	}
+	textBody, htmlBody, err := e.body(msg)
+	if err != nil {
+		return err
+	}
+	return e.send(ctx, subject, textBody, htmlBody)
+}
+
+// Run sends a digest of all changes every DigestInterval, until the context is canceled. Any remaining changes
+// are sent when the context is canceled. Run does nothing if DigestInterval is zero.
+func (e *EmailNotifier) Run(ctx context.Context) {
+	if e.DigestInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(e.DigestInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			e.sendDigest(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+			e.sendDigest(ctx)
+		}
+	}
+}
+
+// sendDigest emails the pending changes. They remain pending until the digest is sent.
+func (e *EmailNotifier) sendDigest(ctx context.Context) {
+	logger := slogctx.FromContext(ctx)
+	e.lock.Lock()
+	err := e.loadPending()
+	changes := e.pending
+	e.lock.Unlock()
+	if err != nil {
+		logger.Warn("Failed to load email digest", "err", err)
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+	// the subject summarizes the changes, as for a Digest. Only its period and totals are known.
+	var digest DigestRepo
+	from, to := time.Now(), time.Now()
+	msgs := make([]emailMessage, len(changes))
+	for i, change := range changes {
+		if !change.Time.IsZero() && change.Time.Before(from) {
+			from = change.Time
+		}
+		switch {
+		case change.Milestone > 0:
+			// not a change in stars
+		case change.Added:
+			digest.Added += len(change.Stargazers)
+		default:
+			digest.Removed += len(change.Stargazers)
+		}
+		msgs[i] = change.message()
+	}
+	subject, err := e.subject(MessageDigest, newDigestData(Digest{From: from, To: to, Repos: []DigestRepo{digest}}))
+	if err != nil {
+		logger.Warn("Failed to render email digest", "err", err)
+		return
+	}
+	textBody, htmlBody, err := e.body(msgs...)
+	if err != nil {
+		logger.Warn("Failed to render email digest", "err", err)
+		return
+	}
+	if err = e.send(ctx, subject, textBody, htmlBody); err != nil {
+		// try again with the next digest
+		logger.Warn("Failed to send email digest", "err", err)
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	// keep any changes that were added while sending the digest
+	e.pending = e.pending[len(changes):]
+	if err = e.savePending(e.pending); err != nil {
+		logger.Warn("Failed to save email digest", "err", err)
+	}
+}
+
+// addPending adds a change to the next digest.
+func (e *EmailNotifier) addPending(change emailChange) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err := e.loadPending(); err != nil {
+		return err
+	}
+	pending := append(e.pending, change)
+	if err := e.savePending(pending); err != nil {
+		return err
+	}
+	e.pending = pending
+	return nil
+}
+
+// loadPending loads the pending changes saved in DigestPath, if they weren't loaded yet. Caller must hold lock.
+func (e *EmailNotifier) loadPending() error {
+	if e.loaded || e.DigestPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(e.DigestPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("load: %w", err)
+	}
+	if err == nil {
+		var f emailDigestFile
+		if err = json.Unmarshal(data, &f); err != nil {
+			return fmt.Errorf("decode %s: %w", e.DigestPath, err)
+		}
+		if f.Version > emailDigestVersion {
+			return fmt.Errorf("%s: %w: %d", e.DigestPath, errUnsupportedVersion, f.Version)
+		}
+		e.pending = append(f.Changes, e.pending...)
+	}
+	e.loaded = true
+	return nil
+}
+
+// savePending saves the pending changes to DigestPath.
+func (e *EmailNotifier) savePending(changes []emailChange) error {
+	if e.DigestPath == "" {
+		return nil
+	}
+	return writeJSONFile(e.DigestPath, emailDigestFile{Version: emailDigestVersion, Changes: changes})
+}
+
+// send emails a message, with a plain text and an HTML version, to all recipients.
+func (e *EmailNotifier) send(ctx context.Context, subject, textBody, htmlBody string) error {
+	msg, err := e.makeMessage(subject, textBody, htmlBody)
+	if err != nil {
+		return fmt.Errorf("message: %w", err)
+	}
+	c, err := e.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer func() { _ = c.Close() }()
+	if e.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	if err = c.Mail(e.From); err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	for _, to := range e.To {
+		if err = c.Rcpt(to); err != nil {
+			return fmt.Errorf("rcpt %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("data: %w", err)
+	}
+	if _, err = w.Write(msg); err != nil {
+		_ = w.Close()
+		return fmt.Errorf("data: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("data: %w", err)
+	}
+	return c.Quit()
+}
+
+// dial connects to the SMTP server, securing the connection as configured.
+func (e *EmailNotifier) dial(ctx context.Context) (*smtp.Client, error) {
+	tlsConfig := e.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: e.Host, MinVersion: tls.VersionTLS12}
+	}
+	security := e.Security
+	if security == "" {
+		security = EmailSecuritySTARTTLS
+	}
+	port := e.Port
+	if port == 0 {
+		port = map[EmailSecurity]int{EmailSecuritySTARTTLS: 587, EmailSecurityTLS: 465, EmailSecurityNone: 25}[security]
+	}
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(port))
+
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	switch security {
+	case EmailSecurityTLS:
+		conn, err = (&tls.Dialer{NetDialer: &dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	case EmailSecuritySTARTTLS, EmailSecurityNone:
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	default:
+		return nil, fmt.Errorf("invalid security: %q", security)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if security == EmailSecuritySTARTTLS {
+		if err = c.StartTLS(tlsConfig); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("starttls: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// makeMessage returns a multipart email, with a plain text and an HTML version.
+func (e *EmailNotifier) makeMessage(subject, textBody, htmlBody string) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{contentType: "text/plain; charset=utf-8", content: textBody},
+		{contentType: "text/html; charset=utf-8", content: htmlBody},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(pw)
+		if _, err = qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err = qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	for _, header := range [][2]string{
+		{"From", e.From},
+		{"To", strings.Join(e.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + w.Boundary()},
+	} {
+		msg.WriteString(header[0] + ": " + header[1] + "\r\n")
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// emailMessage is a message that an email holds: the kind of message and the data to render it from.
+type emailMessage struct {
+	kind MessageKind
+	data MessageData
+}
+
+// message returns the message for the change.
+func (c emailChange) message() emailMessage {
+	if c.Milestone > 0 {
+		return emailMessage{kind: MessageMilestone, data: newMilestoneData(c.Stargazers[0], c.Milestone)}
+	}
+	return emailMessage{kind: messageKind(c.Added), data: newMessageData(c.Stargazers, c.Added, defaultMaximumUsers)}
+}
+
+// subject returns the subject of an email for a message of the given kind, rendered with the "email.subject" template
+// for that kind. Only the first line of the message is used.
+func (e *EmailNotifier) subject(kind MessageKind, data MessageData) (string, error) {
+	subject, err := e.Templates.renderTemplate("email.subject."+string(kind), markupPlain, data)
+	subject, _, _ = strings.Cut(subject, "\n")
+	return subject, err
+}
+
+// body returns the plain text and HTML body of an email holding the messages.
+func (e *EmailNotifier) body(msgs ...emailMessage) (string, string, error) {
+	var textBody, htmlBody strings.Builder
+	htmlBody.WriteString("<html><body>\r\n")
+	for _, msg := range msgs {
+		text, err := e.Templates.render("email", msg.kind, markupPlain, msg.data)
+		if err != nil {
+			return "", "", err
+		}
+		formatted, err := e.Templates.render("email", msg.kind, markupHTML, msg.data)
+		if err != nil {
+			return "", "", err
+		}
+		textBody.WriteString(strings.ReplaceAll(text, "\n", "\r\n") + "\r\n\r\n")
+		htmlBody.WriteString("<p>" + strings.ReplaceAll(formatted, "\n", "<br>\r\n") + "</p>\r\n")
+	}
+	htmlBody.WriteString("</body></html>\r\n")
+	return textBody.String(), htmlBody.String(), nil
+}
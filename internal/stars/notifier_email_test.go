@@ -0,0 +1,363 @@
+package stars
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailNotifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		security EmailSecurity
+		username string
+	}{
+		{name: "none", security: EmailSecurityNone},
+		{name: "auth", security: EmailSecurityNone, username: "user"},
+		{name: "starttls", security: EmailSecuritySTARTTLS, username: "user"},
+		{name: "tls", security: EmailSecurityTLS, username: "user"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeSMTPServer(t, tt.security == EmailSecurityTLS)
+			n := s.notifier(tt.security)
+			n.Username = tt.username
+			n.Password = "password"
+			assert.NoError(t, n.Notify(t.Context(), true, []github.Stargazer{
+				{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user1", UserHTMLURL: "https://example.com/user1"},
+				{RepoName: "foo/baz", Login: "user2"},
+			}))
+
+			emails := s.received()
+			require.Len(t, emails, 2)
+			slices.SortFunc(emails, func(a, b fakeEmail) int { return strings.Compare(a.subject, b.subject) })
+			assert.Equal(t, []string{"alice@example.com", "bob@example.com"}, emails[0].to)
+			assert.Equal(t, "foo/bar received a star from user1", emails[0].subject)
+			assert.Equal(t, "Repo foo/bar received a star from:\r\n- user1\r\n\r\n", emails[0].text)
+			assert.Contains(t, emails[0].html, `<p>Repo <a href="https://example.com/foo/bar">foo/bar</a> received a star from:<br>`+"\r\n"+`- <a href="https://example.com/user1">@user1</a></p>`)
+			assert.Equal(t, "foo/baz received a star from user2", emails[1].subject)
+			if tt.username != "" {
+				assert.Equal(t, "\x00user\x00password", s.auth)
+			}
+		})
+	}
+}
+
+func TestEmailNotifier_Digest(t *testing.T) {
+	s := newFakeSMTPServer(t, false)
+	n := s.notifier(EmailSecurityNone)
+	n.DigestInterval = time.Hour
+	assert.NoError(t, n.Notify(t.Context(), true, []github.Stargazer{
+		{RepoName: "foo/bar", Login: "user1"},
+		{RepoName: "foo/bar", Login: "user2"},
+	}))
+	assert.NoError(t, n.Notify(t.Context(), false, []github.Stargazer{
+		{RepoName: "foo/baz", Login: "user3"},
+	}))
+	assert.Empty(t, s.received())
+
+	n.sendDigest(t.Context())
+	emails := s.received()
+	require.Len(t, emails, 1)
+	assert.Equal(t, "GitHub stars: 2 new, 1 lost", emails[0].subject)
+	assert.Contains(t, emails[0].text, "Repo foo/bar received a star from:\r\n- user1\r\n- user2\r\n")
+	assert.Contains(t, emails[0].text, "Repo foo/baz lost a star from:\r\n- user3\r\n")
+
+	// nothing to send
+	n.sendDigest(t.Context())
+	assert.Len(t, s.received(), 1)
+}
+
+func TestEmailNotifier_Digest_Templates(t *testing.T) {
+	s := newFakeSMTPServer(t, false)
+	n := s.notifier(EmailSecurityNone)
+	n.DigestInterval = time.Hour
+	var err error
+	// the subject doesn't use the template for all notifiers' digests
+	n.Templates, err = NewTemplates(`{{ define "digest" }}Digest{{ end }}` +
+		`{{ define "email.subject.digest" }}{{ .Digest.Added }} new{{ if not (.Digest.From.IsZero) }} since {{ .Digest.From.Format "2006" }}{{ end }}{{ end }}`)
+	require.NoError(t, err)
+	start := time.Now()
+	assert.NoError(t, n.Notify(t.Context(), true, []github.Stargazer{{RepoName: "foo/bar", Login: "user1"}}))
+
+	n.sendDigest(t.Context())
+	emails := s.received()
+	require.Len(t, emails, 1)
+	assert.Equal(t, "1 new since "+start.Format("2006"), emails[0].subject)
+}
+
+func TestEmailNotifier_Digest_Restart(t *testing.T) {
+	s := newFakeSMTPServer(t, false)
+	path := filepath.Join(t.TempDir(), EmailDigestFilename)
+	n := s.notifier(EmailSecurityNone)
+	n.DigestInterval = time.Hour
+	n.DigestPath = path
+	assert.NoError(t, n.Notify(t.Context(), true, []github.Stargazer{{RepoName: "foo/bar", Login: "user1"}}))
+
+	// the changes survive a restart
+	n = s.notifier(EmailSecurityNone)
+	n.DigestInterval = time.Hour
+	n.DigestPath = path
+	assert.NoError(t, n.Notify(t.Context(), false, []github.Stargazer{{RepoName: "foo/baz", Login: "user2"}}))
+	n.sendDigest(t.Context())
+	emails := s.received()
+	require.Len(t, emails, 1)
+	assert.Equal(t, "GitHub stars: 1 new, 1 lost", emails[0].subject)
+
+	// once sent, the changes are no longer pending
+	n = s.notifier(EmailSecurityNone)
+	n.DigestInterval = time.Hour
+	n.DigestPath = path
+	n.sendDigest(t.Context())
+	assert.Len(t, s.received(), 1)
+}
+
+func TestEmailNotifier_Digest_Failed(t *testing.T) {
+	n := EmailNotifier{Host: "127.0.0.1", Port: 1, Security: EmailSecurityNone, From: "github-stars@example.com", To: []string{"alice@example.com"}, DigestInterval: time.Hour}
+	assert.NoError(t, n.Notify(t.Context(), true, []github.Stargazer{{RepoName: "foo/bar", Login: "user1"}}))
+	n.sendDigest(t.Context())
+	// changes are kept for the next digest
+	assert.Len(t, n.pending, 1)
+}
+
+func TestEmailNotifier_Templates(t *testing.T) {
+	s := newFakeSMTPServer(t, false)
+	n := s.notifier(EmailSecurityNone)
+	var err error
+	n.Templates, err = NewTemplates(`{{ define "added" }}{{ link .RepoURL .Repo }} a reçu une étoile{{ end }}` +
+		`{{ define "email.subject.added" }}Nouvelle étoile pour {{ .Repo }}{{ end }}`)
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(t.Context(), true, []github.Stargazer{{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user1"}}))
+
+	emails := s.received()
+	require.Len(t, emails, 1)
+	assert.Equal(t, "Nouvelle étoile pour foo/bar", emails[0].subject)
+	assert.Equal(t, "foo/bar a reçu une étoile\r\n\r\n", emails[0].text)
+	assert.Contains(t, emails[0].html, `<p><a href="https://example.com/foo/bar">foo/bar</a> a reçu une étoile</p>`)
+}
+
+func TestEmailNotifier_subject(t *testing.T) {
+	gazers := make([]github.Stargazer, 3)
+	for i := range gazers {
+		gazers[i] = github.Stargazer{RepoName: "foo/bar", Login: "user" + strconv.Itoa(i)}
+	}
+	var e EmailNotifier
+	subject, err := e.subject(MessageAdded, emailChange{Stargazers: gazers[:1], Added: true}.message().data)
+	require.NoError(t, err)
+	assert.Equal(t, "foo/bar received a star from user0", subject)
+	subject, err = e.subject(MessageRemoved, emailChange{Stargazers: gazers}.message().data)
+	require.NoError(t, err)
+	assert.Equal(t, "foo/bar lost a star from 3 users", subject)
+	subject, err = e.subject(MessageMilestone, emailChange{Stargazers: gazers[:1], Milestone: 100, Added: true}.message().data)
+	require.NoError(t, err)
+	assert.Equal(t, "Repo foo/bar reached 100 stars", subject)
+}
+
+var _ io.Closer = (*fakeSMTPServer)(nil)
+
+// fakeSMTPServer is a minimal SMTP server that supports STARTTLS and PLAIN authentication.
+type fakeSMTPServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	rootCAs   *x509.CertPool
+	emails    []fakeEmail
+	auth      string
+	lock      sync.Mutex
+}
+
+type fakeEmail struct {
+	to      []string
+	subject string
+	text    string
+	html    string
+}
+
+func newFakeSMTPServer(t *testing.T, implicitTLS bool) *fakeSMTPServer {
+	t.Helper()
+	// borrow httptest's certificate, which is valid for 127.0.0.1
+	ts := httptest.NewTLSServer(nil)
+	ts.Close()
+	s := fakeSMTPServer{
+		tlsConfig: &tls.Config{Certificates: ts.TLS.Certificates},
+		rootCAs:   x509.NewCertPool(),
+	}
+	s.rootCAs.AddCert(ts.Certificate())
+
+	var err error
+	if implicitTLS {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	go s.serve()
+	return &s
+}
+
+func (s *fakeSMTPServer) notifier(security EmailSecurity) *EmailNotifier {
+	return &EmailNotifier{
+		Host:      "127.0.0.1",
+		Port:      s.listener.Addr().(*net.TCPAddr).Port,
+		Security:  security,
+		From:      "github-stars@example.com",
+		To:        []string{"alice@example.com", "bob@example.com"},
+		TLSConfig: &tls.Config{RootCAs: s.rootCAs, ServerName: "127.0.0.1"},
+	}
+}
+
+func (s *fakeSMTPServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *fakeSMTPServer) received() []fakeEmail {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.emails
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost ESMTP")
+	var to []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			reply("250-localhost")
+			if _, ok := conn.(*tls.Conn); !ok {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 ready")
+			conn = tls.Server(conn, s.tlsConfig)
+			r = bufio.NewReader(conn)
+		case "AUTH":
+			_, credentials, _ := strings.Cut(arg, " ")
+			decoded, _ := decodeBase64(credentials)
+			s.lock.Lock()
+			s.auth = decoded
+			s.lock.Unlock()
+			reply("235 authenticated")
+		case "MAIL":
+			to = nil
+			reply("250 ok")
+		case "RCPT":
+			to = append(to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			msg, err := readData(r)
+			if err != nil {
+				return
+			}
+			email, err := parseEmail(msg)
+			if err != nil {
+				reply("554 invalid message")
+				continue
+			}
+			email.to = to
+			s.lock.Lock()
+			s.emails = append(s.emails, email)
+			s.lock.Unlock()
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func readData(r *bufio.Reader) (string, error) {
+	var msg strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line == ".\r\n" {
+			return msg.String(), nil
+		}
+		msg.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
+
+func parseEmail(msg string) (fakeEmail, error) {
+	m, err := mail.ReadMessage(strings.NewReader(msg))
+	if err != nil {
+		return fakeEmail{}, err
+	}
+	var email fakeEmail
+	if email.subject, err = new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject")); err != nil {
+		return fakeEmail{}, err
+	}
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		return fakeEmail{}, err
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return email, nil
+		}
+		if err != nil {
+			return fakeEmail{}, err
+		}
+		// NextPart decodes quoted-printable bodies
+		body, err := io.ReadAll(part)
+		if err != nil {
+			return fakeEmail{}, err
+		}
+		switch {
+		case strings.HasPrefix(part.Header.Get("Content-Type"), "text/plain"):
+			email.text = string(body)
+		case strings.HasPrefix(part.Header.Get("Content-Type"), "text/html"):
+			email.html = string(body)
+		}
+	}
+}
+
+func decodeBase64(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return string(b), err
+}
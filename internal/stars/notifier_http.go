@@ -0,0 +1,151 @@
+package stars
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"github.com/clambin/github-stars/internal/github"
+)
+
+// HTTPSignatureHeader is the header holding the HMAC signature of an HTTPNotifier's request body.
+// As with GitHub webhooks, the header's value is "sha256=" followed by the hex-encoded HMAC-SHA256 of the body.
+const HTTPSignatureHeader = "X-Hub-Signature-256"
+
+// HTTPNotifier is a Notifier that sends added/removed stargazers to any HTTP endpoint, e.g. ntfy, Gotify
+// or Home Assistant. HTTPNotifier sends one request per repository.
+type HTTPNotifier struct {
+	// URL is the URL to send the requests to
+	URL string
+	// Method is the HTTP method of the requests. Default is POST.
+	Method string
+	// Headers are added to each request
+	Headers map[string]string
+	// Template renders the body of the request from an HTTPNotification. If nil, the body is the HTTPNotification,
+	// encoded as JSON. Use NewHTTPTemplate to create a template with HTTPNotifier's template functions.
+	Template *template.Template
+	// Secret signs the request body. If set, HTTPNotifier adds the body's signature in the HTTPSignatureHeader header.
+	Secret string
+	// HTTPClient is the client to send the requests with. Default is http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// HTTPNotification is the data that HTTPNotifier renders the request body from.
+type HTTPNotification struct {
+	// Repo is the full name of the repository. Blank for digests.
+	Repo string `json:"repo"`
+	// RepoURL is the URL of the repository
+	RepoURL string `json:"repo_url"`
+	// Action is "received" if the stargazers were added, "lost" if they were removed, "reached" if the repository
+	// reached a milestone, or "digest" for a digest
+	Action string `json:"action"`
+	// Added is true if the stargazers were added, or false if they were removed
+	Added bool `json:"added"`
+	// Stargazers are the added/removed stargazers
+	Stargazers []github.Stargazer `json:"stargazers"`
+	// Milestone is the number of stars that the repository reached. Milestone notifications only.
+	Milestone int `json:"milestone,omitempty"`
+	// Digest is the summary of the stars that repositories gained and lost. Digests only.
+	Digest *Digest `json:"digest,omitempty"`
+}
+
+// NewHTTPTemplate parses text as a template for HTTPNotifier. Besides the standard functions, templates
+// can use "json", which returns its argument encoded as JSON. This is useful to insert strings in a JSON body.
+func NewHTTPTemplate(text string) (*template.Template, error) {
+	return template.New("http").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+var (
+	_ MilestoneNotifier = HTTPNotifier{}
+	_ DigestNotifier    = HTTPNotifier{}
+)
+
+func (h HTTPNotifier) Notify(ctx context.Context, added bool, stars []github.Stargazer) error {
+	var errs []error
+	for _, stargazers := range stargazersByRepo(stars) {
+		notification := HTTPNotification{
+			Repo:       stargazers[0].RepoName,
+			RepoURL:    stargazers[0].RepoHTMLURL,
+			Action:     action[added],
+			Added:      added,
+			Stargazers: stargazers,
+		}
+		if err := h.send(ctx, notification); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h HTTPNotifier) NotifyMilestone(ctx context.Context, repo github.Stargazer, milestone int) error {
+	return h.send(ctx, HTTPNotification{
+		Repo:       repo.RepoName,
+		RepoURL:    repo.RepoHTMLURL,
+		Action:     "reached",
+		Added:      true,
+		Stargazers: []github.Stargazer{},
+		Milestone:  milestone,
+	})
+}
+
+func (h HTTPNotifier) NotifyDigest(ctx context.Context, digest Digest) error {
+	return h.send(ctx, HTTPNotification{Action: "digest", Stargazers: []github.Stargazer{}, Digest: &digest})
+}
+
+func (h HTTPNotifier) send(ctx context.Context, notification HTTPNotification) error {
+	body, contentType, err := h.makeBody(notification)
+	if err != nil {
+		return fmt.Errorf("body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, cmp.Or(h.Method, http.MethodPost), h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range h.Headers {
+		req.Header.Set(key, value)
+	}
+	if h.Secret != "" {
+		req.Header.Set(HTTPSignatureHeader, signature(h.Secret, body))
+	}
+	resp, err := cmp.Or(h.HTTPClient, http.DefaultClient).Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", req.Method, resp.Status)
+	}
+	return nil
+}
+
+// makeBody renders the request body and returns its default content type.
+func (h HTTPNotifier) makeBody(notification HTTPNotification) ([]byte, string, error) {
+	if h.Template == nil {
+		body, err := json.Marshal(notification)
+		return body, "application/json", err
+	}
+	var body bytes.Buffer
+	err := h.Template.Execute(&body, notification)
+	return body.Bytes(), "text/plain; charset=utf-8", err
+}
+
+// signature returns the HMAC-SHA256 signature of the body, in the format of GitHub's X-Hub-Signature-256 header.
+func signature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,155 @@
+package stars
+
+import (
+	"crypto/hmac"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPNotifier(t *testing.T) {
+	tests := []struct {
+		name       string
+		notifier   HTTPNotifier
+		wantMethod string
+		wantType   string
+		wantBody   string
+	}{
+		{
+			name:       "default",
+			wantMethod: http.MethodPost,
+			wantType:   "application/json",
+			wantBody:   `{"repo":"foo/bar","repo_url":"https://example.com/foo/bar","action":"received","added":true,"stargazers":[{"starred_at":"0001-01-01T00:00:00Z","repo_name":"foo/bar","repo_html_url":"https://example.com/foo/bar","login":"us\"er1","user_html_url":""}]}`,
+		},
+		{
+			name:       "template",
+			notifier:   HTTPNotifier{Method: http.MethodPut, Template: newTestHTTPTemplate(t, `{"message":{{ json (print .Repo " " .Action " a star from " (index .Stargazers 0).Login) }}}`), Headers: map[string]string{"Content-Type": "application/json", "Authorization": "Bearer token"}},
+			wantMethod: http.MethodPut,
+			wantType:   "application/json",
+			wantBody:   `{"message":"foo/bar received a star from us\"er1"}`,
+		},
+		{
+			name:       "plain text",
+			notifier:   HTTPNotifier{Template: newTestHTTPTemplate(t, `{{ .Repo }} {{ .Action }} {{ len .Stargazers }} star(s)`)},
+			wantMethod: http.MethodPost,
+			wantType:   "text/plain; charset=utf-8",
+			wantBody:   `foo/bar received 1 star(s)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h fakeHTTPEndpoint
+			ts := httptest.NewServer(&h)
+			t.Cleanup(ts.Close)
+
+			n := tt.notifier
+			n.URL = ts.URL
+			n.Secret = "secret"
+			assert.NoError(t, n.Notify(t.Context(), true, []github.Stargazer{
+				{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: `us"er1`},
+			}))
+
+			requests := h.received()
+			require.Len(t, requests, 1)
+			assert.Equal(t, tt.wantMethod, requests[0].Method)
+			assert.Equal(t, tt.wantType, requests[0].Header.Get("Content-Type"))
+			assert.Equal(t, tt.wantBody, requests[0].body)
+			assert.True(t, hmac.Equal([]byte(signature("secret", []byte(tt.wantBody))), []byte(requests[0].Header.Get(HTTPSignatureHeader))))
+			if tt.notifier.Headers != nil {
+				assert.Equal(t, "Bearer token", requests[0].Header.Get("Authorization"))
+			}
+		})
+	}
+}
+
+func TestHTTPNotifier_NotifyMilestone(t *testing.T) {
+	var h fakeHTTPEndpoint
+	ts := httptest.NewServer(&h)
+	t.Cleanup(ts.Close)
+
+	n := HTTPNotifier{URL: ts.URL}
+	require.NoError(t, n.NotifyMilestone(t.Context(), github.Stargazer{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar"}, 100))
+	requests := h.received()
+	require.Len(t, requests, 1)
+	assert.Equal(t, `{"repo":"foo/bar","repo_url":"https://example.com/foo/bar","action":"reached","added":true,"stargazers":[],"milestone":100}`, requests[0].body)
+}
+
+func TestHTTPNotifier_NotifyDigest(t *testing.T) {
+	var h fakeHTTPEndpoint
+	ts := httptest.NewServer(&h)
+	t.Cleanup(ts.Close)
+
+	n := HTTPNotifier{URL: ts.URL}
+	require.NoError(t, n.NotifyDigest(t.Context(), Digest{
+		From:  time.Date(2025, time.March, 3, 9, 0, 0, 0, time.UTC),
+		To:    time.Date(2025, time.March, 4, 9, 0, 0, 0, time.UTC),
+		Repos: []DigestRepo{{Repo: "foo/bar", Added: 2, Removed: 1, Stars: 10}},
+	}))
+	requests := h.received()
+	require.Len(t, requests, 1)
+	assert.Equal(t, `{"repo":"","repo_url":"","action":"digest","added":false,"stargazers":[],"digest":{"from":"2025-03-03T09:00:00Z","to":"2025-03-04T09:00:00Z","repos":[{"repo":"foo/bar","repo_url":"","added":2,"removed":1,"stars":10}]}}`, requests[0].body)
+}
+
+func TestHTTPNotifier_Failure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	t.Cleanup(ts.Close)
+
+	n := HTTPNotifier{URL: ts.URL}
+	err := n.send(t.Context(), HTTPNotification{Repo: "foo/bar"})
+	assert.EqualError(t, err, "POST: 404 Not Found")
+
+	n.Template = newTestHTTPTemplate(t, `{{ .Unknown }}`)
+	assert.Error(t, n.send(t.Context(), HTTPNotification{Repo: "foo/bar"}))
+}
+
+func TestSignature(t *testing.T) {
+	// example from GitHub's documentation on validating webhook deliveries
+	assert.Equal(t,
+		"sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+		signature("It's a Secret to Everybody", []byte("Hello, World!")),
+	)
+}
+
+func newTestHTTPTemplate(t *testing.T, text string) *template.Template {
+	t.Helper()
+	tmpl, err := NewHTTPTemplate(text)
+	require.NoError(t, err)
+	return tmpl
+}
+
+type fakeHTTPRequest struct {
+	*http.Request
+	body string
+}
+
+type fakeHTTPEndpoint struct {
+	requests []fakeHTTPRequest
+	lock     sync.Mutex
+}
+
+var _ http.Handler = (*fakeHTTPEndpoint)(nil)
+
+func (f *fakeHTTPEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.requests = append(f.requests, fakeHTTPRequest{Request: r, body: string(body)})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeHTTPEndpoint) received() []fakeHTTPRequest {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.requests
+}
@@ -0,0 +1,181 @@
+package stars
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+)
+
+const (
+	defaultMatrixMaxRetries = 3
+	defaultMatrixRetryAfter = time.Second
+)
+
+// MatrixNotifier is a Notifier that posts added/removed stargazers to a Matrix room.
+// If the homeserver rate-limits the notifier, MatrixNotifier waits as instructed by the homeserver and tries again.
+type MatrixNotifier struct {
+	// Templates renders the messages. If nil, MatrixNotifier posts the default messages.
+	Templates *Templates
+	// HomeServerURL is the URL of the Matrix homeserver, e.g. https://matrix.example.com
+	HomeServerURL string
+	// AccessToken is the access token of the Matrix user to post the messages as.
+	AccessToken string
+	// RoomID is the ID of the room to post the messages to, e.g. !abcdefg:example.com
+	RoomID string
+	// HTTPClient is the client to post the messages with. Default is http.DefaultClient.
+	HTTPClient *http.Client
+	// MaximumUsers is the maximum number of users to notify about. Default is 5.
+	// If the number of stargazers is greater than this, MatrixNotifier only notifies the number of users.
+	MaximumUsers int
+	// MaxRetries is the maximum number of times to retry a rate-limited message. Default is 3.
+	MaxRetries int
+}
+
+var (
+	_ MilestoneNotifier = MatrixNotifier{}
+	_ DigestNotifier    = MatrixNotifier{}
+)
+
+func (m MatrixNotifier) Notify(ctx context.Context, added bool, stars []github.Stargazer) error {
+	var errs []error
+	for _, stargazers := range stargazersByRepo(stars) {
+		msg, err := m.makeMessage(stargazers, added)
+		if err == nil {
+			err = m.send(ctx, msg)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m MatrixNotifier) NotifyMilestone(ctx context.Context, repo github.Stargazer, milestone int) error {
+	msg, err := m.render(MessageMilestone, newMilestoneData(repo, milestone))
+	if err != nil {
+		return err
+	}
+	return m.send(ctx, msg)
+}
+
+func (m MatrixNotifier) NotifyDigest(ctx context.Context, digest Digest) error {
+	msg, err := m.render(MessageDigest, newDigestData(digest))
+	if err != nil {
+		return err
+	}
+	// HTML ignores the line breaks between the repositories
+	msg.FormattedBody = strings.ReplaceAll(msg.FormattedBody, "\n", "<br>\n")
+	return m.send(ctx, msg)
+}
+
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+type matrixError struct {
+	ErrCode      string `json:"errcode"`
+	Error        string `json:"error"`
+	RetryAfterMs int    `json:"retry_after_ms"`
+}
+
+func (m MatrixNotifier) makeMessage(gazers []github.Stargazer, added bool) (matrixMessage, error) {
+	if len(gazers) == 0 {
+		return matrixMessage{}, nil
+	}
+	return m.render(messageKind(added), newMessageData(gazers, added, cmp.Or(m.MaximumUsers, defaultMaximumUsers)))
+}
+
+// render returns a message with a plain text and an HTML version of the message of the given kind.
+func (m MatrixNotifier) render(kind MessageKind, data MessageData) (matrixMessage, error) {
+	body, err := m.Templates.render("matrix", kind, markupPlain, data)
+	if err != nil {
+		return matrixMessage{}, err
+	}
+	formattedBody, err := m.Templates.render("matrix", kind, markupHTML, data)
+	if err != nil {
+		return matrixMessage{}, err
+	}
+	return matrixMessage{
+		MsgType:       "m.notice",
+		Body:          body,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: formattedBody,
+	}, nil
+}
+
+// send posts the message to the room. If the homeserver rate-limits the request, send retries it after the requested delay.
+func (m MatrixNotifier) send(ctx context.Context, msg matrixMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	// the transaction ID makes retries idempotent: the homeserver only posts the message once
+	target := strings.TrimSuffix(m.HomeServerURL, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(m.RoomID) +
+		"/send/m.room.message/" + rand.Text()
+
+	maxRetries := cmp.Or(m.MaxRetries, defaultMatrixMaxRetries)
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := m.put(ctx, target, body)
+		if err == nil {
+			return nil
+		}
+		if retryAfter == 0 || attempt >= maxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+// put sends a message to the homeserver. If the homeserver rate-limited the request, it returns how long to wait
+// before trying again.
+func (m MatrixNotifier) put(ctx context.Context, target string, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.AccessToken)
+	resp, err := cmp.Or(m.HTTPClient, http.DefaultClient).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusOK {
+		return 0, nil
+	}
+
+	var matrixErr matrixError
+	_ = json.NewDecoder(resp.Body).Decode(&matrixErr)
+	err = fmt.Errorf("matrix: %s", resp.Status)
+	if matrixErr.ErrCode != "" {
+		err = fmt.Errorf("matrix: %s: %s", matrixErr.ErrCode, matrixErr.Error)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && matrixErr.ErrCode != "M_LIMIT_EXCEEDED" {
+		return 0, err
+	}
+	if matrixErr.RetryAfterMs > 0 {
+		return time.Duration(matrixErr.RetryAfterMs) * time.Millisecond, err
+	}
+	if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, err
+	}
+	return defaultMatrixRetryAfter, err
+}
@@ -0,0 +1,145 @@
+package stars
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatrixNotifier(t *testing.T) {
+	h, homeServerURL := newFakeMatrixServer(t, 2)
+	n := MatrixNotifier{HomeServerURL: homeServerURL, AccessToken: "token", RoomID: "!room:example.com"}
+	assert.NoError(t, n.Notify(t.Context(), true, []github.Stargazer{
+		{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user1", UserHTMLURL: "https://example.com/user1"},
+	}))
+
+	// the message is posted once, after being rate-limited twice
+	want := matrixMessage{
+		MsgType:       "m.notice",
+		Body:          "Repo foo/bar received a star from user1",
+		Format:        "org.matrix.custom.html",
+		FormattedBody: `Repo <a href="https://example.com/foo/bar">foo/bar</a> received a star from <a href="https://example.com/user1">@user1</a>`,
+	}
+	assert.Equal(t, []matrixMessage{want}, h.received())
+	assert.Len(t, h.txnIDs, 1)
+}
+
+func TestMatrixNotifier_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		token       string
+		rateLimited int
+		wantCalls   int
+	}{
+		{name: "unauthorized", token: "invalid", wantCalls: 1},
+		{name: "too many retries", token: "token", rateLimited: 10, wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, homeServerURL := newFakeMatrixServer(t, tt.rateLimited)
+			n := MatrixNotifier{HomeServerURL: homeServerURL, AccessToken: tt.token, RoomID: "!room:example.com", MaxRetries: 1}
+			err := n.send(t.Context(), matrixMessage{MsgType: "m.notice", Body: "hello"})
+			require.Error(t, err)
+			assert.Equal(t, tt.wantCalls, h.calls)
+			assert.Empty(t, h.received())
+		})
+	}
+}
+
+func TestMatrixNotifier_makeMessage(t *testing.T) {
+	gazers := make([]github.Stargazer, 6)
+	for i := range gazers {
+		gazers[i] = github.Stargazer{RepoName: "foo/bar", Login: "user" + strconv.Itoa(i)}
+	}
+	gazers[0].Login = "<script>"
+
+	tests := []struct {
+		name     string
+		count    int
+		added    bool
+		wantBody string
+		wantHTML string
+	}{
+		{name: "one user", count: 1, added: true, wantBody: "Repo foo/bar received a star from <script>", wantHTML: "Repo foo/bar received a star from &lt;script&gt;"},
+		{name: "removed", count: 2, wantBody: "Repo foo/bar lost a star from 2 users: <script>, user1", wantHTML: "Repo foo/bar lost a star from 2 users: &lt;script&gt;, user1"},
+		{name: "too many users", count: 6, added: true, wantBody: "Repo foo/bar received a star from 6 users", wantHTML: "Repo foo/bar received a star from 6 users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := MatrixNotifier{}.makeMessage(gazers[:tt.count], tt.added)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBody, msg.Body)
+			assert.Equal(t, tt.wantHTML, msg.FormattedBody)
+		})
+	}
+}
+
+// newFakeMatrixServer starts a fakeMatrixServer and returns its URL.
+func newFakeMatrixServer(t *testing.T, rateLimited int) (*fakeMatrixServer, string) {
+	t.Helper()
+	f := fakeMatrixServer{rateLimited: rateLimited}
+	mux := http.NewServeMux()
+	mux.Handle("PUT /_matrix/client/v3/rooms/{room}/send/m.room.message/{txn}", &f)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return &f, ts.URL
+}
+
+// fakeMatrixServer accepts messages sent to !room:example.com. It rate-limits the first rateLimited calls.
+type fakeMatrixServer struct {
+	messages    []matrixMessage
+	txnIDs      map[string]struct{}
+	rateLimited int
+	calls       int
+	lock        sync.Mutex
+}
+
+var _ http.Handler = (*fakeMatrixServer)(nil)
+
+func (f *fakeMatrixServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls++
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"Invalid access token"}`))
+		return
+	}
+	if f.calls <= f.rateLimited {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests","retry_after_ms":10}`))
+		return
+	}
+	if r.PathValue("room") != "!room:example.com" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	var msg matrixMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	f.messages = append(f.messages, msg)
+	if f.txnIDs == nil {
+		f.txnIDs = make(map[string]struct{})
+	}
+	f.txnIDs[r.PathValue("txn")] = struct{}{}
+	_, _ = w.Write([]byte(`{"event_id":"$event"}`))
+}
+
+func (f *fakeMatrixServer) received() []matrixMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.messages
+}
@@ -0,0 +1,302 @@
+package stars
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/clambin/github-stars/slogctx"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// SlackThreadsFilename is the name of the file holding the threads of a SlackBotNotifier.
+	SlackThreadsFilename = "slack-threads.json"
+
+	slackThreadsVersion = 1
+)
+
+// SlackBotNotifier is a Notifier that posts added/removed stargazers to a Slack channel as a Slack app's bot user.
+//
+// SlackBotNotifier posts the stargazers of a repository in the repository's thread. The thread's parent message shows
+// the number of stars that the repository received and lost since the thread started, and is updated after each message.
+// SlackBotNotifier saves the threads to disk, so they survive a restart. Use WithChannel to post to more than one channel.
+type SlackBotNotifier struct {
+	// Templates renders the messages. If nil, SlackBotNotifier posts the default messages.
+	Templates *Templates
+	// Store holds the stargazers of each repository. If set, messages show the repository's number of stargazers.
+	Store Store
+	// APIURL is the URL of the Slack Web API. Default is https://slack.com/api/.
+	APIURL string
+	// HTTPClient is the client to call the Slack Web API with. Default is http.DefaultClient.
+	HTTPClient *http.Client
+	// MaximumUsers is the maximum number of users to notify about. Default is 5.
+	// If the number of stargazers is greater than this, SlackBotNotifier only notifies the number of users.
+	MaximumUsers int
+
+	token   string
+	channel string
+	threads *slackThreads
+}
+
+var (
+	_ MilestoneNotifier = (*SlackBotNotifier)(nil)
+	_ DigestNotifier    = (*SlackBotNotifier)(nil)
+)
+
+// slackThreads holds the threads of each channel, by repository.
+type slackThreads struct {
+	threads map[string]map[string]slackThread
+	path    string
+	lock    sync.Mutex
+}
+
+// slackThread is the thread of a repository.
+type slackThread struct {
+	// ChannelID and TS identify the thread's parent message
+	ChannelID string `json:"channel_id"`
+	TS        string `json:"ts"`
+	// Added and Removed are the number of stars that the repository received and lost since the thread started
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+type slackThreadsFile struct {
+	Version int                               `json:"version"`
+	Threads map[string]map[string]slackThread `json:"threads"`
+}
+
+// NewSlackBotNotifier creates a SlackBotNotifier that posts to a channel with a bot token, loading any threads saved at path.
+func NewSlackBotNotifier(token, channel, path string) (*SlackBotNotifier, error) {
+	s := SlackBotNotifier{
+		token:   token,
+		channel: channel,
+		threads: &slackThreads{path: path, threads: make(map[string]map[string]slackThread)},
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+	var f slackThreadsFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if f.Version > slackThreadsVersion {
+		return nil, fmt.Errorf("%s: %w: %d", path, errUnsupportedVersion, f.Version)
+	}
+	if f.Threads != nil {
+		s.threads.threads = f.Threads
+	}
+	return &s, nil
+}
+
+// WithChannel returns a copy of the SlackBotNotifier that posts to another channel. Both notifiers save their threads to the same file.
+func (s *SlackBotNotifier) WithChannel(channel string) *SlackBotNotifier {
+	n := *s
+	n.channel = channel
+	return &n
+}
+
+func (s *SlackBotNotifier) Notify(ctx context.Context, added bool, stars []github.Stargazer) error {
+	client := s.client()
+	var errs []error
+	for _, stargazers := range stargazersByRepo(stars) {
+		if err := s.notifyRepo(ctx, client, stargazers, added); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *SlackBotNotifier) client() *slack.Client {
+	options := []slack.Option{slack.OptionHTTPClient(cmp.Or(s.HTTPClient, http.DefaultClient))}
+	if s.APIURL != "" {
+		options = append(options, slack.OptionAPIURL(s.APIURL))
+	}
+	return slack.New(s.token, options...)
+}
+
+// notifyRepo posts the stargazers of one repository in the repository's thread, starting the thread if needed.
+func (s *SlackBotNotifier) notifyRepo(ctx context.Context, client *slack.Client, gazers []github.Stargazer, added bool) error {
+	text, blocks, err := s.makeMessage(gazers, added)
+	if err != nil {
+		return err
+	}
+
+	// one message at a time, so a repository doesn't get two threads
+	s.threads.lock.Lock()
+	defer s.threads.lock.Unlock()
+
+	repo := gazers[0]
+	logger := slogctx.FromContext(ctx).With("repo", repo.RepoName, "channel", s.channel)
+	thread, err := s.thread(ctx, client, repo)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err = client.PostMessageContext(ctx, thread.ChannelID,
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionBlocks(blocks...),
+		slack.MsgOptionTS(thread.TS),
+		slack.MsgOptionDisableLinkUnfurl(),
+	); err != nil {
+		return fmt.Errorf("slack: post: %w", err)
+	}
+
+	// the message has been posted. Don't return an error from here on: the message would be posted again.
+	if added {
+		thread.Added += len(gazers)
+	} else {
+		thread.Removed += len(gazers)
+	}
+	if err = s.threads.set(s.channel, repo.RepoName, thread); err != nil {
+		logger.Warn("failed to save Slack threads", "err", err)
+	}
+	if _, _, _, err = client.UpdateMessageContext(ctx, thread.ChannelID, thread.TS, s.makeThreadMessage(repo, thread)...); err != nil {
+		logger.Warn("failed to update Slack thread", "err", err)
+	}
+	return nil
+}
+
+func (s *SlackBotNotifier) NotifyMilestone(ctx context.Context, repo github.Stargazer, milestone int) error {
+	text, err := s.Templates.render("slackbot", MessageMilestone, markupSlack, newMilestoneData(repo, milestone))
+	if err != nil {
+		return err
+	}
+
+	s.threads.lock.Lock()
+	defer s.threads.lock.Unlock()
+
+	client := s.client()
+	thread, err := s.thread(ctx, client, repo)
+	if err != nil {
+		return err
+	}
+	// a milestone is also posted to the channel
+	if _, _, err = client.PostMessageContext(ctx, thread.ChannelID,
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionTS(thread.TS),
+		slack.MsgOptionBroadcast(),
+		slack.MsgOptionDisableLinkUnfurl(),
+	); err != nil {
+		return fmt.Errorf("slack: post: %w", err)
+	}
+	return nil
+}
+
+// NotifyDigest posts the digest to the channel, outside of the repositories' threads.
+func (s *SlackBotNotifier) NotifyDigest(ctx context.Context, digest Digest) error {
+	text, err := s.Templates.render("slackbot", MessageDigest, markupSlack, newDigestData(digest))
+	if err != nil {
+		return err
+	}
+	if _, _, err = s.client().PostMessageContext(ctx, s.channel, slack.MsgOptionText(text, false), slack.MsgOptionDisableLinkUnfurl()); err != nil {
+		return fmt.Errorf("slack: post: %w", err)
+	}
+	return nil
+}
+
+// thread returns the repository's thread, starting it if needed. Caller must hold the threads' lock.
+func (s *SlackBotNotifier) thread(ctx context.Context, client *slack.Client, repo github.Stargazer) (slackThread, error) {
+	thread, ok := s.threads.get(s.channel, repo.RepoName)
+	if ok {
+		return thread, nil
+	}
+	var err error
+	if thread.ChannelID, thread.TS, err = client.PostMessageContext(ctx, s.channel, s.makeThreadMessage(repo, thread)...); err != nil {
+		return slackThread{}, fmt.Errorf("slack: start thread: %w", err)
+	}
+	if err = s.threads.set(s.channel, repo.RepoName, thread); err != nil {
+		slogctx.FromContext(ctx).Warn("failed to save Slack threads", "repo", repo.RepoName, "channel", s.channel, "err", err)
+	}
+	return thread, nil
+}
+
+// makeMessage returns the message for the stargazers: the message's text, used in notifications, and its Block Kit layout.
+// The layout shows each stargazer with their avatar, followed by the repository's number of stargazers.
+func (s *SlackBotNotifier) makeMessage(gazers []github.Stargazer, added bool) (string, []slack.Block, error) {
+	maxUsers := cmp.Or(s.MaximumUsers, defaultMaximumUsers)
+	text, err := s.Templates.render("slackbot", messageKind(added), markupSlack, newMessageData(gazers, added, maxUsers))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var blocks []slack.Block
+	if len(gazers) > maxUsers {
+		blocks = append(blocks, slack.NewSectionBlock(slackMarkdown(text), nil, nil))
+	} else {
+		for _, gazer := range gazers {
+			gazerText, err := s.Templates.render("slackbot", messageKind(added), markupSlack, newMessageData([]github.Stargazer{gazer}, added, maxUsers))
+			if err != nil {
+				return "", nil, err
+			}
+			var avatar *slack.Accessory
+			if url := avatarURL(gazer); url != "" {
+				avatar = slack.NewAccessory(slack.NewImageBlockElement(url, gazer.Login))
+			}
+			blocks = append(blocks, slack.NewSectionBlock(slackMarkdown(gazerText), nil, avatar))
+		}
+	}
+	if count, ok := stargazerCount(s.Store, gazers[0].RepoName); ok {
+		blocks = append(blocks, slack.NewContextBlock("", slackMarkdown(":star: "+slackStarCount(count))))
+	}
+	return text, blocks, nil
+}
+
+// makeThreadMessage returns the parent message of a repository's thread.
+func (s *SlackBotNotifier) makeThreadMessage(repo github.Stargazer, thread slackThread) []slack.MsgOption {
+	text := slackRepoLink(repo)
+	if count, ok := stargazerCount(s.Store, repo.RepoName); ok {
+		text += " :star: " + strconv.Itoa(count)
+	}
+	changes := "+" + strconv.Itoa(thread.Added) + " / -" + strconv.Itoa(thread.Removed)
+	return []slack.MsgOption{
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionBlocks(
+			slack.NewSectionBlock(slackMarkdown("*"+text+"*"), nil, nil),
+			slack.NewContextBlock("", slackMarkdown(changes)),
+		),
+		slack.MsgOptionDisableLinkUnfurl(),
+	}
+}
+
+// get returns the thread of a repository in a channel. Caller must hold the lock.
+func (t *slackThreads) get(channel, repo string) (slackThread, bool) {
+	thread, ok := t.threads[channel][repo]
+	return thread, ok
+}
+
+// set updates the thread of a repository in a channel and writes the threads to disk. Caller must hold the lock.
+func (t *slackThreads) set(channel, repo string, thread slackThread) error {
+	if t.threads[channel] == nil {
+		t.threads[channel] = make(map[string]slackThread)
+	}
+	t.threads[channel][repo] = thread
+	return writeJSONFile(t.path, slackThreadsFile{Version: slackThreadsVersion, Threads: t.threads})
+}
+
+func slackMarkdown(text string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject(slack.MarkdownType, text, false, false)
+}
+
+// stargazerCount returns the repository's number of stargazers in the Store. It returns false if the number is not known.
+func stargazerCount(store Store, repo string) (int, bool) {
+	if store == nil {
+		return 0, false
+	}
+	stargazers, err := store.Stargazers(repo)
+	if err != nil {
+		return 0, false
+	}
+	return len(stargazers), true
+}
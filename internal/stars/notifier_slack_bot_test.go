@@ -0,0 +1,192 @@
+package stars
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlackBotNotifier(t *testing.T) {
+	var api fakeSlackAPI
+	ts := httptest.NewServer(&api)
+	t.Cleanup(ts.Close)
+
+	path := filepath.Join(t.TempDir(), SlackThreadsFilename)
+	n, err := NewSlackBotNotifier("token", "#stars", path)
+	require.NoError(t, err)
+	n.APIURL = ts.URL + "/"
+
+	gazers := []github.Stargazer{
+		{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user1", UserHTMLURL: "https://example.com/user1"},
+		{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user2", UserHTMLURL: "https://example.com/user2"},
+	}
+	require.NoError(t, n.Notify(t.Context(), true, gazers[:1]))
+	require.NoError(t, n.Notify(t.Context(), true, gazers[1:]))
+
+	// the repository's stars are posted in one thread. the parent message is updated after each message.
+	msgs := api.received()
+	require.Len(t, msgs, 5)
+	assert.Equal(t, fakeSlackMessage{method: "chat.postMessage", channel: "#stars", text: "<https://example.com/foo/bar|foo/bar>", context: "+0 / -0"}, msgs[0])
+	assert.Equal(t, fakeSlackMessage{method: "chat.postMessage", channel: "C1", threadTS: "1", text: "Repo <https://example.com/foo/bar|foo/bar> received a star from <https://example.com/user1|@user1>"}, msgs[1])
+	assert.Equal(t, fakeSlackMessage{method: "chat.update", channel: "C1", ts: "1", text: "<https://example.com/foo/bar|foo/bar>", context: "+1 / -0"}, msgs[2])
+	assert.Equal(t, "1", msgs[3].threadTS)
+	assert.Equal(t, "+2 / -0", msgs[4].context)
+
+	// threads survive a restart
+	n, err = NewSlackBotNotifier("token", "#stars", path)
+	require.NoError(t, err)
+	n.APIURL = ts.URL + "/"
+	require.NoError(t, n.Notify(t.Context(), false, gazers[:1]))
+	msgs = api.received()
+	require.Len(t, msgs, 7)
+	assert.Equal(t, "1", msgs[5].threadTS)
+	assert.Equal(t, "+2 / -1", msgs[6].context)
+
+	// another channel has its own threads
+	require.NoError(t, n.WithChannel("#other").Notify(t.Context(), true, gazers[:1]))
+	msgs = api.received()
+	require.Len(t, msgs, 10)
+	assert.Equal(t, "#other", msgs[7].channel)
+	assert.Equal(t, "8", msgs[8].threadTS)
+
+	// milestones are posted in the repository's thread
+	require.NoError(t, n.NotifyMilestone(t.Context(), github.Stargazer{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar"}, 100))
+	msgs = api.received()
+	require.Len(t, msgs, 11)
+	assert.Equal(t, fakeSlackMessage{method: "chat.postMessage", channel: "C1", threadTS: "1", text: "Repo <https://example.com/foo/bar|foo/bar> reached 100 stars"}, msgs[10])
+
+	// digests are posted to the channel, outside the threads
+	require.NoError(t, n.NotifyDigest(t.Context(), Digest{Repos: []DigestRepo{{Repo: "foo/bar", Added: 1, Stars: 2}}}))
+	msgs = api.received()
+	require.Len(t, msgs, 12)
+	assert.Equal(t, "#stars", msgs[11].channel)
+	assert.Empty(t, msgs[11].threadTS)
+	assert.Contains(t, msgs[11].text, "foo/bar: +1, -0 (2 stars)")
+}
+
+func TestSlackBotNotifier_Errors(t *testing.T) {
+	var api fakeSlackAPI
+	ts := httptest.NewServer(&api)
+	t.Cleanup(ts.Close)
+
+	n, err := NewSlackBotNotifier("invalid", "#stars", filepath.Join(t.TempDir(), SlackThreadsFilename))
+	require.NoError(t, err)
+	n.APIURL = ts.URL + "/"
+	err = n.Notify(t.Context(), true, []github.Stargazer{{RepoName: "foo/bar", Login: "user1"}})
+	assert.EqualError(t, err, "slack: start thread: invalid_auth")
+}
+
+func TestSlackBotNotifier_makeMessage(t *testing.T) {
+	gazers := make([]github.Stargazer, 6)
+	for i := range gazers {
+		gazers[i] = github.Stargazer{RepoName: "foo/bar", Login: "user-" + strconv.Itoa(i)}
+	}
+	gazers[0].UserHTMLURL = "https://example.com/user-0"
+	store, err := NewJSONStore(t.TempDir())
+	require.NoError(t, err)
+	_, err = store.Add(gazers[:3]...)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		count      int
+		wantBlocks []slack.Block
+	}{
+		{
+			name:  "one user",
+			count: 1,
+			wantBlocks: []slack.Block{
+				slack.NewSectionBlock(slackMarkdown("Repo foo/bar received a star from <https://example.com/user-0|@user-0>"), nil, slack.NewAccessory(slack.NewImageBlockElement("https://example.com/user-0.png", "user-0"))),
+				slack.NewContextBlock("", slackMarkdown(":star: 3 stars")),
+			},
+		},
+		{
+			name:  "no avatar",
+			count: 2,
+			wantBlocks: []slack.Block{
+				slack.NewSectionBlock(slackMarkdown("Repo foo/bar received a star from <https://example.com/user-0|@user-0>"), nil, slack.NewAccessory(slack.NewImageBlockElement("https://example.com/user-0.png", "user-0"))),
+				slack.NewSectionBlock(slackMarkdown("Repo foo/bar received a star from user-1"), nil, nil),
+				slack.NewContextBlock("", slackMarkdown(":star: 3 stars")),
+			},
+		},
+		{
+			name:  "too many users",
+			count: 6,
+			wantBlocks: []slack.Block{
+				slack.NewSectionBlock(slackMarkdown("Repo foo/bar received a star from 6 users"), nil, nil),
+				slack.NewContextBlock("", slackMarkdown(":star: 3 stars")),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, blocks, err := (&SlackBotNotifier{Store: store}).makeMessage(gazers[:tt.count], true)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBlocks, blocks)
+		})
+	}
+}
+
+// fakeSlackAPI implements chat.postMessage and chat.update of the Slack Web API.
+// Each message gets a channel ID and timestamp, based on the number of messages received so far.
+type fakeSlackAPI struct {
+	messages []fakeSlackMessage
+	lock     sync.Mutex
+}
+
+type fakeSlackMessage struct {
+	method   string
+	channel  string
+	ts       string
+	threadTS string
+	text     string
+	context  string
+}
+
+var _ http.Handler = (*fakeSlackAPI)(nil)
+
+func (f *fakeSlackAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.FormValue("token") != "token" {
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": "invalid_auth"})
+		return
+	}
+	msg := fakeSlackMessage{
+		method:   r.URL.Path[1:],
+		channel:  r.FormValue("channel"),
+		ts:       r.FormValue("ts"),
+		threadTS: r.FormValue("thread_ts"),
+		text:     r.FormValue("text"),
+	}
+	var blocks slack.Blocks
+	if err := json.Unmarshal([]byte(r.FormValue("blocks")), &blocks); err == nil && len(blocks.BlockSet) > 1 {
+		if c, ok := blocks.BlockSet[1].(*slack.ContextBlock); ok && len(c.ContextElements.Elements) > 0 {
+			msg.context = c.ContextElements.Elements[0].(*slack.TextBlockObject).Text
+		}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.messages = append(f.messages, msg)
+	ts := msg.ts
+	if ts == "" {
+		ts = strconv.Itoa(len(f.messages))
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "channel": "C" + ts, "ts": ts})
+}
+
+func (f *fakeSlackAPI) received() []fakeSlackMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.messages)
+}
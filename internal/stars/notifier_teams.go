@@ -0,0 +1,127 @@
+package stars
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/clambin/github-stars/internal/github"
+)
+
+// TeamsNotifier is a Notifier that posts added/removed stargazers to a Microsoft Teams channel as an Adaptive Card.
+// It supports both Teams incoming webhooks and Power Automate Workflows webhooks.
+type TeamsNotifier struct {
+	// Templates renders the messages. If nil, TeamsNotifier posts the default messages.
+	Templates *Templates
+	// WebHookURL is the URL to the Teams webhook
+	WebHookURL string
+	// HTTPClient is the client to post the messages with. Default is http.DefaultClient.
+	HTTPClient *http.Client
+	// MaximumUsers is the maximum number of users to notify about. Default is 5.
+	// If the number of stargazers is greater than this, TeamsNotifier only notifies the number of users.
+	MaximumUsers int
+}
+
+var (
+	_ MilestoneNotifier = TeamsNotifier{}
+	_ DigestNotifier    = TeamsNotifier{}
+)
+
+func (t TeamsNotifier) Notify(ctx context.Context, added bool, stars []github.Stargazer) error {
+	var errs []error
+	for _, stargazers := range stargazersByRepo(stars) {
+		msg, err := t.makeMessage(stargazers, added)
+		if err == nil {
+			err = postJSON(ctx, t.HTTPClient, t.WebHookURL, msg)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t TeamsNotifier) NotifyMilestone(ctx context.Context, repo github.Stargazer, milestone int) error {
+	text, err := t.Templates.render("teams", MessageMilestone, markupMarkdown, newMilestoneData(repo, milestone))
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, t.HTTPClient, t.WebHookURL, teamsCard(repo.RepoName, repo.RepoHTMLURL, text))
+}
+
+func (t TeamsNotifier) NotifyDigest(ctx context.Context, digest Digest) error {
+	text, err := t.Templates.render("teams", MessageDigest, markupMarkdown, newDigestData(digest))
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, t.HTTPClient, t.WebHookURL, teamsCard("GitHub stars", "", text))
+}
+
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string            `json:"contentType"`
+	Content     teamsAdaptiveCard `json:"content"`
+}
+
+type teamsAdaptiveCard struct {
+	Schema  string           `json:"$schema"`
+	Type    string           `json:"type"`
+	Version string           `json:"version"`
+	Body    []teamsTextBlock `json:"body"`
+	Actions []teamsAction    `json:"actions,omitempty"`
+}
+
+type teamsTextBlock struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	Size   string `json:"size,omitempty"`
+	Weight string `json:"weight,omitempty"`
+	Wrap   bool   `json:"wrap"`
+}
+
+type teamsAction struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+func (t TeamsNotifier) makeMessage(gazers []github.Stargazer, added bool) (teamsMessage, error) {
+	if len(gazers) == 0 {
+		return teamsMessage{}, nil
+	}
+	text, err := t.makeText(gazers, added)
+	if err != nil {
+		return teamsMessage{}, err
+	}
+	return teamsCard(gazers[0].RepoName, gazers[0].RepoHTMLURL, text), nil
+}
+
+// teamsCard returns a message with an Adaptive Card that shows the text under the title. If url is set,
+// the card links to it.
+func teamsCard(title, url, text string) teamsMessage {
+	card := teamsAdaptiveCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+		Body: []teamsTextBlock{
+			{Type: "TextBlock", Text: title, Size: "Medium", Weight: "Bolder", Wrap: true},
+			{Type: "TextBlock", Text: text, Wrap: true},
+		},
+	}
+	if url != "" {
+		card.Actions = []teamsAction{{Type: "Action.OpenUrl", Title: "View repository", URL: url}}
+	}
+	return teamsMessage{
+		Type:        "message",
+		Attachments: []teamsAttachment{{ContentType: "application/vnd.microsoft.card.adaptive", Content: card}},
+	}
+}
+
+func (t TeamsNotifier) makeText(gazers []github.Stargazer, added bool) (string, error) {
+	return t.Templates.render("teams", messageKind(added), markupMarkdown, newMessageData(gazers, added, cmp.Or(t.MaximumUsers, defaultMaximumUsers)))
+}
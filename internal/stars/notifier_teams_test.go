@@ -0,0 +1,102 @@
+package stars
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeamsNotifier(t *testing.T) {
+	var w fakeTeamsWebhook
+	ts := httptest.NewServer(&w)
+	t.Cleanup(ts.Close)
+
+	n := TeamsNotifier{WebHookURL: ts.URL}
+	assert.NoError(t, n.Notify(t.Context(), true, []github.Stargazer{
+		{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user1", UserHTMLURL: "https://example.com/user1"},
+		{RepoName: "foo/baz", Login: "user2"},
+	}))
+
+	messages := w.received()
+	require.Len(t, messages, 2)
+	want := teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content: teamsAdaptiveCard{
+				Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+				Type:    "AdaptiveCard",
+				Version: "1.4",
+				Body: []teamsTextBlock{
+					{Type: "TextBlock", Text: "foo/bar", Size: "Medium", Weight: "Bolder", Wrap: true},
+					{Type: "TextBlock", Text: "Repo received a star from [@user1](https://example.com/user1)", Wrap: true},
+				},
+				Actions: []teamsAction{{Type: "Action.OpenUrl", Title: "View repository", URL: "https://example.com/foo/bar"}},
+			},
+		}},
+	}
+	assert.Contains(t, messages, want)
+}
+
+func TestTeamsNotifier_makeText(t *testing.T) {
+	gazers := make([]github.Stargazer, 6)
+	for i := range gazers {
+		gazers[i] = github.Stargazer{RepoName: "foo/bar", Login: "user" + strconv.Itoa(i)}
+	}
+
+	tests := []struct {
+		name  string
+		count int
+		added bool
+		want  string
+	}{
+		{name: "one user", count: 1, added: true, want: "Repo received a star from user0"},
+		{name: "removed", count: 1, want: "Repo lost a star from user0"},
+		{name: "max users", count: 5, added: true, want: "Repo received a star from 5 users: user0, user1, user2, user3, user4"},
+		{name: "too many users", count: 6, added: true, want: "Repo received a star from 6 users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := TeamsNotifier{}.makeText(gazers[:tt.count], tt.added)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, text)
+		})
+	}
+}
+
+type fakeTeamsWebhook struct {
+	messages []teamsMessage
+	lock     sync.Mutex
+}
+
+var _ http.Handler = (*fakeTeamsWebhook)(nil)
+
+func (f *fakeTeamsWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	var msg teamsMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.messages = append(f.messages, msg)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (f *fakeTeamsWebhook) received() []teamsMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.messages
+}
@@ -0,0 +1,161 @@
+package stars
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/clambin/github-stars/internal/github"
+)
+
+const defaultTelegramAPIURL = "https://api.telegram.org"
+
+// TelegramParseMode is the formatting of a Telegram message.
+type TelegramParseMode string
+
+const (
+	TelegramMarkdownV2 TelegramParseMode = "MarkdownV2"
+	TelegramHTML       TelegramParseMode = "HTML"
+)
+
+// TelegramNotifier is a Notifier that posts added/removed stargazers to a Telegram chat, using the Telegram Bot API.
+type TelegramNotifier struct {
+	// Templates renders the messages. If nil, TelegramNotifier posts the default messages.
+	Templates *Templates
+	// BotToken is the token of the Telegram bot to post the messages as
+	BotToken string
+	// ChatID is the ID of the chat to post the messages to, e.g. 123456789, or @channelname for public channels
+	ChatID string
+	// ParseMode is the formatting of the messages. Default is TelegramMarkdownV2.
+	ParseMode TelegramParseMode
+	// APIURL is the URL of the Telegram Bot API. Default is https://api.telegram.org.
+	APIURL string
+	// HTTPClient is the client to post the messages with. Default is http.DefaultClient.
+	HTTPClient *http.Client
+	// MaximumUsers is the maximum number of users to notify about. Default is 5.
+	// If the number of stargazers is greater than this, TelegramNotifier only notifies the number of users.
+	MaximumUsers int
+}
+
+var (
+	_ MilestoneNotifier = TelegramNotifier{}
+	_ DigestNotifier    = TelegramNotifier{}
+)
+
+func (t TelegramNotifier) Notify(ctx context.Context, added bool, stars []github.Stargazer) error {
+	var errs []error
+	for _, stargazers := range stargazersByRepo(stars) {
+		msg, err := t.makeMessage(stargazers, added)
+		if err == nil {
+			err = t.send(ctx, msg)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t TelegramNotifier) NotifyMilestone(ctx context.Context, repo github.Stargazer, milestone int) error {
+	msg, err := t.render(MessageMilestone, newMilestoneData(repo, milestone))
+	if err != nil {
+		return err
+	}
+	return t.send(ctx, msg)
+}
+
+func (t TelegramNotifier) NotifyDigest(ctx context.Context, digest Digest) error {
+	msg, err := t.render(MessageDigest, newDigestData(digest))
+	if err != nil {
+		return err
+	}
+	return t.send(ctx, msg)
+}
+
+type telegramMessage struct {
+	ChatID             string                     `json:"chat_id"`
+	Text               string                     `json:"text"`
+	ParseMode          TelegramParseMode          `json:"parse_mode"`
+	LinkPreviewOptions telegramLinkPreviewOptions `json:"link_preview_options"`
+}
+
+type telegramLinkPreviewOptions struct {
+	IsDisabled bool `json:"is_disabled"`
+}
+
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+func (t TelegramNotifier) makeMessage(gazers []github.Stargazer, added bool) (telegramMessage, error) {
+	if len(gazers) == 0 {
+		return telegramMessage{}, nil
+	}
+	return t.render(messageKind(added), newMessageData(gazers, added, cmp.Or(t.MaximumUsers, defaultMaximumUsers)))
+}
+
+// render returns a message with the message of the given kind, in the markup of the notifier's ParseMode.
+func (t TelegramNotifier) render(kind MessageKind, data MessageData) (telegramMessage, error) {
+	parseMode := cmp.Or(t.ParseMode, TelegramMarkdownV2)
+	m := markupTelegramMarkdownV2
+	if parseMode == TelegramHTML {
+		m = markupHTML
+	}
+	text, err := t.Templates.render("telegram", kind, m, data)
+	if err != nil {
+		return telegramMessage{}, err
+	}
+	return telegramMessage{
+		ChatID:             t.ChatID,
+		Text:               text,
+		ParseMode:          parseMode,
+		LinkPreviewOptions: telegramLinkPreviewOptions{IsDisabled: true},
+	}, nil
+}
+
+// telegramMarkdownV2Escape escapes all characters that have a special meaning in MarkdownV2.
+var telegramMarkdownV2Escape = strings.NewReplacer(
+	`\`, `\\`, `_`, `\_`, `*`, `\*`, `[`, `\[`, `]`, `\]`, `(`, `\(`, `)`, `\)`, `~`, `\~`, "`", "\\`",
+	`>`, `\>`, `#`, `\#`, `+`, `\+`, `-`, `\-`, `=`, `\=`, `|`, `\|`, `{`, `\{`, `}`, `\}`, `.`, `\.`, `!`, `\!`,
+).Replace
+
+// telegramMarkdownV2Link returns a MarkdownV2 link. Inside the URL, only ')' and '\' need to be escaped.
+func telegramMarkdownV2Link(href, text string) string {
+	return "[" + telegramMarkdownV2Escape(text) + "](" + strings.NewReplacer(`\`, `\\`, `)`, `\)`).Replace(href) + ")"
+}
+
+// send posts the message to the chat.
+func (t TelegramNotifier) send(ctx context.Context, msg telegramMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSuffix(cmp.Or(t.APIURL, defaultTelegramAPIURL), "/") + "/bot" + t.BotToken + "/sendMessage"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("telegram: invalid API URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cmp.Or(t.HTTPClient, http.DefaultClient).Do(req)
+	if err != nil {
+		// the URL holds the bot token: don't log it
+		if urlErr, ok := errors.AsType[*url.Error](err); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var response telegramResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil || !response.OK {
+		return fmt.Errorf("telegram: %s: %s", resp.Status, response.Description)
+	}
+	return nil
+}
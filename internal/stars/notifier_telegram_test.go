@@ -0,0 +1,117 @@
+package stars
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTelegramNotifier(t *testing.T) {
+	var h fakeTelegramBotAPI
+	ts := httptest.NewServer(&h)
+	t.Cleanup(ts.Close)
+
+	n := TelegramNotifier{BotToken: "token", ChatID: "123", APIURL: ts.URL}
+	assert.NoError(t, n.Notify(t.Context(), true, []github.Stargazer{
+		{RepoName: "foo/bar.go", RepoHTMLURL: "https://example.com/foo/bar.go", Login: "user_1", UserHTMLURL: "https://example.com/user_1"},
+	}))
+
+	want := telegramMessage{
+		ChatID:             "123",
+		Text:               `Repo [foo/bar\.go](https://example.com/foo/bar.go) received a star from [@user\_1](https://example.com/user_1)`,
+		ParseMode:          TelegramMarkdownV2,
+		LinkPreviewOptions: telegramLinkPreviewOptions{IsDisabled: true},
+	}
+	assert.Equal(t, []telegramMessage{want}, h.received())
+}
+
+func TestTelegramNotifier_Errors(t *testing.T) {
+	var h fakeTelegramBotAPI
+	ts := httptest.NewServer(&h)
+	t.Cleanup(ts.Close)
+
+	n := TelegramNotifier{BotToken: "invalid", ChatID: "123", APIURL: ts.URL}
+	err := n.send(t.Context(), telegramMessage{ChatID: "123", Text: "hello"})
+	assert.EqualError(t, err, "telegram: 401 Unauthorized: Unauthorized")
+
+	// errors don't leak the bot token
+	ts.Close()
+	n.BotToken = "token"
+	err = n.send(t.Context(), telegramMessage{ChatID: "123", Text: "hello"})
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "token")
+}
+
+func TestTelegramNotifier_makeMessage(t *testing.T) {
+	gazers := make([]github.Stargazer, 6)
+	for i := range gazers {
+		gazers[i] = github.Stargazer{RepoName: "foo/bar", Login: "user-" + strconv.Itoa(i)}
+	}
+	gazers[0].UserHTMLURL = "https://example.com/user-0"
+
+	tests := []struct {
+		name      string
+		parseMode TelegramParseMode
+		count     int
+		added     bool
+		want      string
+	}{
+		{name: "one user", count: 1, added: true, want: `Repo foo/bar received a star from [@user\-0](https://example.com/user-0)`},
+		{name: "removed", count: 1, want: `Repo foo/bar lost a star from [@user\-0](https://example.com/user-0)`},
+		{name: "max users", count: 5, added: true, want: `Repo foo/bar received a star from 5 users: [@user\-0](https://example.com/user-0), user\-1, user\-2, user\-3, user\-4`},
+		{name: "too many users", count: 6, added: true, want: `Repo foo/bar received a star from 6 users`},
+		{name: "html", parseMode: TelegramHTML, count: 2, added: true, want: `Repo foo/bar received a star from 2 users: <a href="https://example.com/user-0">@user-0</a>, user-1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := TelegramNotifier{ParseMode: tt.parseMode}.makeMessage(gazers[:tt.count], tt.added)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, msg.Text)
+		})
+	}
+}
+
+func TestTelegramEscape(t *testing.T) {
+	assert.Equal(t, `a\_b\*c\[d\]e\(f\)g\~h\`+"`"+`i\>j\#k\+l\-m\=n\|o\{p\}q\.r\!s\\t`, telegramMarkdownV2Escape("a_b*c[d]e(f)g~h`i>j#k+l-m=n|o{p}q.r!s\\t"))
+	assert.Equal(t, `[a\.b](https://example.com/a_(b\))`, telegramMarkdownV2Link("https://example.com/a_(b)", "a.b"))
+}
+
+type fakeTelegramBotAPI struct {
+	messages []telegramMessage
+	lock     sync.Mutex
+}
+
+var _ http.Handler = (*fakeTelegramBotAPI)(nil)
+
+func (f *fakeTelegramBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path != "/bottoken/sendMessage" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(telegramResponse{Description: "Unauthorized"})
+		return
+	}
+	var msg telegramMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(telegramResponse{Description: "Bad Request: " + err.Error()})
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.messages = append(f.messages, msg)
+	_ = json.NewEncoder(w).Encode(telegramResponse{OK: true})
+}
+
+func (f *fakeTelegramBotAPI) received() []telegramMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.messages
+}
@@ -0,0 +1,343 @@
+package stars
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/clambin/github-stars/slogctx"
+)
+
+const (
+	// OutboxFilename is the name of the file holding the undelivered notifications of an Outbox.
+	OutboxFilename = "outbox.json"
+
+	outboxVersion             = 1
+	defaultOutboxMaxAttempts  = 10
+	defaultOutboxMinBackoff   = 10 * time.Second
+	defaultOutboxMaxBackoff   = 30 * time.Minute
+	defaultOutboxDeliveryTime = time.Minute
+)
+
+// OutboxNotifier is a Notifier whose notifications are delivered by an Outbox.
+type OutboxNotifier struct {
+	Notifier
+	// Name identifies the Notifier in the outbox and in logs. Each Notifier must have a unique name that doesn't change
+	// across restarts: NewOutbox uses it to link the notifications it loads to their Notifier.
+	Name string
+	// MaxAttempts is the maximum number of times to try delivering a notification. Default is 10.
+	MaxAttempts int
+}
+
+// Outbox is a Notifier that queues notifications and delivers them in the background.
+//
+// Notify only adds the notification to the queue, which Outbox saves to disk. A slow or unavailable service
+// therefore doesn't slow down the caller, and undelivered notifications survive a restart.
+// Run delivers the notifications, with one worker per Notifier. If a delivery fails, the worker tries again later,
+// doubling the delay between attempts, up to the Notifier's MaxAttempts.
+type Outbox struct {
+	// MinBackoff is the time to wait before retrying a failed delivery for the first time. Default is 10 seconds.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum time between two attempts to deliver a notification. Default is 30 minutes.
+	MaxBackoff time.Duration
+	// Router selects the notifiers of each notification. If nil, notifications are sent to all notifiers.
+	Router *Router
+
+	notifiers []OutboxNotifier
+	path      string
+	messages  []outboxMessage
+	wake      map[string]chan struct{}
+	lock      sync.Mutex
+}
+
+var (
+	_ MilestoneNotifier = (*Outbox)(nil)
+	_ DigestNotifier    = (*Outbox)(nil)
+)
+
+// outboxMessage is a notification for one Notifier.
+type outboxMessage struct {
+	NextAttempt time.Time          `json:"next_attempt"`
+	ID          string             `json:"id"`
+	Notifier    string             `json:"notifier"`
+	LastError   string             `json:"last_error,omitempty"`
+	Stargazers  []github.Stargazer `json:"stargazers"`
+	Attempts    int                `json:"attempts"`
+	// Milestone is the milestone that the repository reached, for milestone notifications. Stargazers then
+	// holds the repository's details.
+	Milestone int `json:"milestone,omitempty"`
+	// Digest is the digest, for digest notifications
+	Digest *Digest `json:"digest,omitempty"`
+	Added  bool    `json:"added"`
+}
+
+type outboxFile struct {
+	Version  int             `json:"version"`
+	Messages []outboxMessage `json:"messages"`
+}
+
+// NewOutbox creates an Outbox for the notifiers, loading any undelivered notifications saved at path.
+// Notifications for notifiers that are no longer configured are discarded.
+func NewOutbox(path string, notifiers ...OutboxNotifier) (*Outbox, error) {
+	o := Outbox{
+		notifiers: notifiers,
+		path:      path,
+		wake:      make(map[string]chan struct{}, len(notifiers)),
+	}
+	for _, n := range notifiers {
+		if _, ok := o.wake[n.Name]; ok {
+			return nil, fmt.Errorf("duplicate notifier: %q", n.Name)
+		}
+		o.wake[n.Name] = make(chan struct{}, 1)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+	var f outboxFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if f.Version > outboxVersion {
+		return nil, fmt.Errorf("%s: %w: %d", path, errUnsupportedVersion, f.Version)
+	}
+	o.messages = slices.DeleteFunc(f.Messages, func(msg outboxMessage) bool {
+		_, ok := o.wake[msg.Notifier]
+		return !ok
+	})
+	return &o, nil
+}
+
+// Notify queues the notification for each Notifier. The notification is split per repository, so a failed delivery
+// for one repository doesn't repeat the notification for another one.
+// Notify returns an error if the queue could not be saved. The notification is still delivered, unless Outbox is restarted first.
+func (o *Outbox) Notify(_ context.Context, added bool, stars []github.Stargazer) error {
+	if len(stars) == 0 || len(o.notifiers) == 0 {
+		return nil
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	now := time.Now()
+	for _, stargazers := range stargazersByRepo(stars) {
+		for _, n := range o.route(messageKind(added), stargazers[0]) {
+			o.messages = append(o.messages, outboxMessage{
+				ID:          rand.Text(),
+				Notifier:    n.Name,
+				Added:       added,
+				Stargazers:  stargazers,
+				NextAttempt: now,
+			})
+		}
+	}
+	return o.queued()
+}
+
+// NotifyMilestone queues the milestone notification for each Notifier that is a MilestoneNotifier.
+func (o *Outbox) NotifyMilestone(_ context.Context, repo github.Stargazer, milestone int) error {
+	if len(o.notifiers) == 0 {
+		return nil
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for _, n := range o.route(MessageMilestone, repo) {
+		if _, ok := n.Notifier.(MilestoneNotifier); ok {
+			o.messages = append(o.messages, outboxMessage{
+				ID:          rand.Text(),
+				Notifier:    n.Name,
+				Added:       true,
+				Stargazers:  []github.Stargazer{repo},
+				Milestone:   milestone,
+				NextAttempt: time.Now(),
+			})
+		}
+	}
+	return o.queued()
+}
+
+// NotifyDigest queues the digest for each Notifier that is a DigestNotifier.
+// To route digests, Router receives a blank repository.
+func (o *Outbox) NotifyDigest(_ context.Context, digest Digest) error {
+	if len(o.notifiers) == 0 {
+		return nil
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for _, n := range o.route(MessageDigest, github.Stargazer{}) {
+		if _, ok := n.Notifier.(DigestNotifier); ok {
+			o.messages = append(o.messages, outboxMessage{
+				ID:          rand.Text(),
+				Notifier:    n.Name,
+				Digest:      &digest,
+				NextAttempt: time.Now(),
+			})
+		}
+	}
+	return o.queued()
+}
+
+// queued saves the queue and wakes up the workers, after notifications were added. Caller must hold the lock.
+func (o *Outbox) queued() error {
+	err := o.save()
+	for _, wake := range o.wake {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+	return nil
+}
+
+// route returns the notifiers for a notification about the stargazer's repository.
+func (o *Outbox) route(kind MessageKind, repo github.Stargazer) []OutboxNotifier {
+	if o.Router == nil {
+		return o.notifiers
+	}
+	names := o.Router.Route(kind, repo)
+	return slices.DeleteFunc(slices.Clone(o.notifiers), func(n OutboxNotifier) bool { return !slices.Contains(names, n.Name) })
+}
+
+// Run delivers the queued notifications until the context is canceled.
+func (o *Outbox) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, n := range o.notifiers {
+		wg.Go(func() { o.deliverAll(ctx, n) })
+	}
+	wg.Wait()
+}
+
+// deliverAll delivers the notifications of one Notifier, as they become due.
+func (o *Outbox) deliverAll(ctx context.Context, n OutboxNotifier) {
+	for {
+		msg, ok := o.next(n.Name)
+		var timer *time.Timer
+		var due <-chan time.Time
+		if ok {
+			wait := time.Until(msg.NextAttempt)
+			if wait <= 0 {
+				o.deliver(ctx, n, msg)
+				continue
+			}
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-o.wake[n.Name]:
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// next returns the Notifier's notification that is due first.
+func (o *Outbox) next(name string) (outboxMessage, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	var next outboxMessage
+	var found bool
+	for _, msg := range o.messages {
+		if msg.Notifier == name && (!found || msg.NextAttempt.Before(next.NextAttempt)) {
+			next, found = msg, true
+		}
+	}
+	return next, found
+}
+
+// deliver tries to deliver a notification. If delivery fails, deliver schedules the next attempt,
+// or discards the notification if it reached the Notifier's maximum number of attempts.
+func (o *Outbox) deliver(ctx context.Context, n OutboxNotifier, msg outboxMessage) {
+	deliveryCtx, cancel := context.WithTimeout(ctx, defaultOutboxDeliveryTime)
+	err := o.notify(deliveryCtx, n, msg)
+	cancel()
+	if err != nil && ctx.Err() != nil {
+		// shutting down: try again on the next start
+		return
+	}
+
+	logger := slogctx.FromContext(ctx).With("notifier", n.Name)
+	if len(msg.Stargazers) > 0 {
+		logger = logger.With("repo", msg.Stargazers[0].RepoName)
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	i := slices.IndexFunc(o.messages, func(m outboxMessage) bool { return m.ID == msg.ID })
+	if i < 0 {
+		return
+	}
+	switch {
+	case err == nil:
+		o.messages = slices.Delete(o.messages, i, i+1)
+	case o.messages[i].Attempts+1 >= cmp.Or(n.MaxAttempts, defaultOutboxMaxAttempts):
+		logger.Error("failed to deliver notification. giving up", "attempts", o.messages[i].Attempts+1, "err", err)
+		o.messages = slices.Delete(o.messages, i, i+1)
+	default:
+		o.messages[i].Attempts++
+		o.messages[i].LastError = err.Error()
+		o.messages[i].NextAttempt = time.Now().Add(o.backoff(o.messages[i].Attempts))
+		logger.Warn("failed to deliver notification. will retry", "attempts", o.messages[i].Attempts, "next", o.messages[i].NextAttempt, "err", err)
+	}
+	if err = o.save(); err != nil {
+		logger.Warn("failed to save outbox", "err", err)
+	}
+}
+
+// notify sends the notification with the Notifier. Milestones and digests are only sent by Notifiers that support them.
+func (o *Outbox) notify(ctx context.Context, n OutboxNotifier, msg outboxMessage) error {
+	switch {
+	case msg.Digest != nil:
+		if d, ok := n.Notifier.(DigestNotifier); ok {
+			return d.NotifyDigest(ctx, *msg.Digest)
+		}
+	case msg.Milestone > 0:
+		if m, ok := n.Notifier.(MilestoneNotifier); ok {
+			return m.NotifyMilestone(ctx, msg.Stargazers[0], msg.Milestone)
+		}
+	default:
+		return n.Notify(ctx, msg.Added, msg.Stargazers)
+	}
+	return nil
+}
+
+// backoff returns the time to wait after the given number of failed attempts: MinBackoff, doubling after each attempt, up to MaxBackoff.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	minBackoff, maxBackoff := cmp.Or(o.MinBackoff, defaultOutboxMinBackoff), cmp.Or(o.MaxBackoff, defaultOutboxMaxBackoff)
+	backoff := minBackoff
+	for range attempts - 1 {
+		if backoff >= maxBackoff/2 {
+			return maxBackoff
+		}
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff)
+}
+
+// Len returns the number of notifications waiting to be delivered.
+func (o *Outbox) Len() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return len(o.messages)
+}
+
+// save writes the queue to disk. Caller must hold the lock.
+func (o *Outbox) save() error {
+	return writeJSONFile(o.path, outboxFile{Version: outboxVersion, Messages: o.messages})
+}
@@ -0,0 +1,282 @@
+package stars
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutbox(t *testing.T) {
+	slack := fakeNotifier{failures: 2}
+	discord := fakeNotifier{}
+	o, err := NewOutbox(filepath.Join(t.TempDir(), OutboxFilename),
+		OutboxNotifier{Name: "slack", Notifier: &slack},
+		OutboxNotifier{Name: "discord", Notifier: &discord},
+	)
+	require.NoError(t, err)
+	o.MinBackoff = time.Millisecond
+
+	ctx, cancel := context.WithCancel(t.Context())
+	var wg sync.WaitGroup
+	wg.Go(func() { o.Run(ctx) })
+	t.Cleanup(func() { cancel(); wg.Wait() })
+
+	require.NoError(t, o.Notify(t.Context(), true, []github.Stargazer{
+		{RepoName: "foo/bar", Login: "user1"},
+		{RepoName: "foo/baz", Login: "user2"},
+	}))
+
+	// each repository is delivered separately. slack fails twice before it succeeds.
+	assert.Eventually(t, func() bool { return o.Len() == 0 }, time.Second, time.Millisecond)
+	assert.Len(t, slack.delivered(), 2)
+	assert.Equal(t, 4, slack.attempts())
+	assert.Len(t, discord.delivered(), 2)
+	assert.Equal(t, 2, discord.attempts())
+}
+
+func TestOutbox_Router(t *testing.T) {
+	slack, email := fakeNotifier{}, fakeNotifier{}
+	o, err := NewOutbox(filepath.Join(t.TempDir(), OutboxFilename),
+		OutboxNotifier{Name: "slack", Notifier: &slack},
+		OutboxNotifier{Name: "email", Notifier: &email},
+	)
+	require.NoError(t, err)
+	o.Router, err = NewRouter(Routes{
+		Rules:   []RouteRule{{Repo: "foo/*", Notifiers: []string{"email"}}},
+		Default: []string{"slack", "email"},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	var wg sync.WaitGroup
+	wg.Go(func() { o.Run(ctx) })
+	t.Cleanup(func() { cancel(); wg.Wait() })
+
+	require.NoError(t, o.Notify(t.Context(), true, []github.Stargazer{
+		{RepoName: "foo/bar", Login: "user1"},
+		{RepoName: "bar/foo", Login: "user2"},
+	}))
+	assert.Eventually(t, func() bool { return o.Len() == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, [][]github.Stargazer{{{RepoName: "bar/foo", Login: "user2"}}}, slack.delivered())
+	assert.Len(t, email.delivered(), 2)
+}
+
+func TestOutbox_Milestone(t *testing.T) {
+	slack, email := fakeNotifier{failures: 1}, fakeNotifier{}
+	o, err := NewOutbox(filepath.Join(t.TempDir(), OutboxFilename),
+		OutboxNotifier{Name: "slack", Notifier: &slack},
+		OutboxNotifier{Name: "email", Notifier: &email},
+		OutboxNotifier{Name: "log", Notifier: &fakeNotifierWithoutMilestones{}},
+	)
+	require.NoError(t, err)
+	o.MinBackoff = time.Millisecond
+	o.Router, err = NewRouter(Routes{
+		Rules:   []RouteRule{{Event: MessageMilestone, Notifiers: []string{"slack", "log"}}},
+		Default: []string{"slack", "email", "log"},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	var wg sync.WaitGroup
+	wg.Go(func() { o.Run(ctx) })
+	t.Cleanup(func() { cancel(); wg.Wait() })
+
+	// only routed notifiers that support milestones get the notification
+	require.NoError(t, o.NotifyMilestone(t.Context(), github.Stargazer{RepoName: "foo/bar"}, 100))
+	assert.Equal(t, 1, o.Len())
+	assert.Eventually(t, func() bool { return o.Len() == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, map[string]int{"foo/bar": 100}, slack.reached())
+	assert.Empty(t, email.reached())
+}
+
+func TestOutbox_Digest(t *testing.T) {
+	slack, email := fakeNotifier{}, fakeNotifier{}
+	path := filepath.Join(t.TempDir(), OutboxFilename)
+	o, err := NewOutbox(path,
+		OutboxNotifier{Name: "slack", Notifier: &slack},
+		OutboxNotifier{Name: "email", Notifier: &email},
+	)
+	require.NoError(t, err)
+	o.Router, err = NewRouter(Routes{Rules: []RouteRule{{Event: MessageDigest, Notifiers: []string{"email"}}}})
+	require.NoError(t, err)
+
+	// the digest survives a restart
+	digest := Digest{Repos: []DigestRepo{{Repo: "foo/bar", Added: 1, Stars: 1}}}
+	require.NoError(t, o.NotifyDigest(t.Context(), digest))
+	o, err = NewOutbox(path,
+		OutboxNotifier{Name: "slack", Notifier: &slack},
+		OutboxNotifier{Name: "email", Notifier: &email},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, o.Len())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	var wg sync.WaitGroup
+	wg.Go(func() { o.Run(ctx) })
+	t.Cleanup(func() { cancel(); wg.Wait() })
+
+	assert.Eventually(t, func() bool { return o.Len() == 0 }, time.Second, time.Millisecond)
+	assert.Empty(t, slack.digests())
+	require.Len(t, email.digests(), 1)
+	assert.Equal(t, digest.Repos, email.digests()[0].Repos)
+}
+
+func TestOutbox_MaxAttempts(t *testing.T) {
+	n := fakeNotifier{failures: 10}
+	o, err := NewOutbox(filepath.Join(t.TempDir(), OutboxFilename), OutboxNotifier{Name: "slack", Notifier: &n, MaxAttempts: 3})
+	require.NoError(t, err)
+	o.MinBackoff = time.Millisecond
+
+	ctx, cancel := context.WithCancel(t.Context())
+	var wg sync.WaitGroup
+	wg.Go(func() { o.Run(ctx) })
+	t.Cleanup(func() { cancel(); wg.Wait() })
+
+	require.NoError(t, o.Notify(t.Context(), false, []github.Stargazer{{RepoName: "foo/bar", Login: "user1"}}))
+	assert.Eventually(t, func() bool { return o.Len() == 0 }, time.Second, time.Millisecond)
+	assert.Empty(t, n.delivered())
+	assert.Equal(t, 3, n.attempts())
+}
+
+func TestOutbox_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), OutboxFilename)
+	o, err := NewOutbox(path, OutboxNotifier{Name: "slack", Notifier: &fakeNotifier{}}, OutboxNotifier{Name: "discord", Notifier: &fakeNotifier{}})
+	require.NoError(t, err)
+	require.NoError(t, o.Notify(t.Context(), true, []github.Stargazer{{RepoName: "foo/bar", Login: "user1"}}))
+	assert.Equal(t, 2, o.Len())
+
+	// undelivered notifications survive a restart. notifications for notifiers that are no longer configured are discarded.
+	n := fakeNotifier{}
+	o, err = NewOutbox(path, OutboxNotifier{Name: "slack", Notifier: &n})
+	require.NoError(t, err)
+	assert.Equal(t, 1, o.Len())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	var wg sync.WaitGroup
+	wg.Go(func() { o.Run(ctx) })
+	t.Cleanup(func() { cancel(); wg.Wait() })
+	assert.Eventually(t, func() bool { return o.Len() == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, [][]github.Stargazer{{{RepoName: "foo/bar", Login: "user1"}}}, n.delivered())
+}
+
+func TestNewOutbox_Errors(t *testing.T) {
+	_, err := NewOutbox(filepath.Join(t.TempDir(), OutboxFilename), OutboxNotifier{Name: "slack"}, OutboxNotifier{Name: "slack"})
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), OutboxFilename)
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0644))
+	_, err = NewOutbox(path)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":99}`), 0644))
+	_, err = NewOutbox(path)
+	assert.ErrorIs(t, err, errUnsupportedVersion)
+}
+
+func TestOutbox_backoff(t *testing.T) {
+	o := Outbox{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: 2 * time.Second},
+		{attempts: 3, want: 4 * time.Second},
+		{attempts: 4, want: 8 * time.Second},
+		{attempts: 5, want: 10 * time.Second},
+		{attempts: 100, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, o.backoff(tt.attempts), tt.attempts)
+	}
+}
+
+// fakeNotifier fails the first failures attempts to notify.
+type fakeNotifier struct {
+	received   [][]github.Stargazer
+	milestones map[string]int
+	digested   []Digest
+	calls      int
+	failures   int
+	lock       sync.Mutex
+}
+
+var (
+	_ MilestoneNotifier = (*fakeNotifier)(nil)
+	_ DigestNotifier    = (*fakeNotifier)(nil)
+)
+
+func (f *fakeNotifier) Notify(_ context.Context, _ bool, stars []github.Stargazer) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls++
+	if f.calls <= f.failures {
+		return errors.New("failed")
+	}
+	f.received = append(f.received, stars)
+	return nil
+}
+
+func (f *fakeNotifier) NotifyMilestone(_ context.Context, repo github.Stargazer, milestone int) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls++
+	if f.calls <= f.failures {
+		return errors.New("failed")
+	}
+	if f.milestones == nil {
+		f.milestones = make(map[string]int)
+	}
+	f.milestones[repo.RepoName] = milestone
+	return nil
+}
+
+func (f *fakeNotifier) NotifyDigest(_ context.Context, digest Digest) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls++
+	if f.calls <= f.failures {
+		return errors.New("failed")
+	}
+	f.digested = append(f.digested, digest)
+	return nil
+}
+
+func (f *fakeNotifier) digests() []Digest {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.digested
+}
+
+func (f *fakeNotifier) reached() map[string]int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.milestones
+}
+
+func (f *fakeNotifier) delivered() [][]github.Stargazer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.received
+}
+
+func (f *fakeNotifier) attempts() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.calls
+}
+
+// fakeNotifierWithoutMilestones is a Notifier that doesn't support milestones.
+type fakeNotifierWithoutMilestones struct{}
+
+func (fakeNotifierWithoutMilestones) Notify(context.Context, bool, []github.Stargazer) error {
+	return nil
+}
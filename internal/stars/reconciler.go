@@ -0,0 +1,126 @@
+package stars
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/clambin/github-stars/slogctx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reconciler periodically scans all repositories and updates the Store to match. This picks up any stars
+// for which GitHub failed to deliver a webhook event.
+type Reconciler struct {
+	Store   *NotifyingStore
+	Sources []Source
+	// Interval is the time between two scans. If zero, Run does not perform any scans.
+	Interval time.Duration
+	// Jitter randomly shortens or extends each Interval by up to Jitter, to avoid scanning at fixed times.
+	Jitter time.Duration
+	// MinInterval is the minimum time between two scans, regardless of Interval and Jitter.
+	MinInterval time.Duration
+	status      ScanStatus
+	lock        sync.RWMutex
+}
+
+// ScanStatus is the outcome of the last scan.
+type ScanStatus struct {
+	Time     time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// Run scans all repositories every Interval, until the context is canceled.
+// Run does not perform an initial scan: call Scan before Run to scan on startup.
+func (r *Reconciler) Run(ctx context.Context) {
+	if r.Interval <= 0 {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.nextScan()):
+			_ = r.Scan(ctx)
+		}
+	}
+}
+
+// Scan scans all repositories and updates the Store.
+func (r *Reconciler) Scan(ctx context.Context) error {
+	logger := slogctx.FromContext(ctx)
+	logger.Info("starting scan")
+	start := time.Now()
+	err := Scan(ctx, r.Sources, r.Store)
+	status := ScanStatus{Time: start, Duration: time.Since(start), Err: err}
+	if err == nil {
+		logger.Info("scan complete", "duration_msec", status.Duration.Milliseconds())
+	} else {
+		logger.Error("scan failed", "err", err)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.status = status
+	return err
+}
+
+// LastScan returns the outcome of the last scan. If no scan was performed yet, Time is zero.
+func (r *Reconciler) LastScan() ScanStatus {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.status
+}
+
+// nextScan returns the time to wait before the next scan.
+func (r *Reconciler) nextScan() time.Duration {
+	wait := r.Interval
+	if r.Jitter > 0 {
+		wait += rand.N(2*r.Jitter) - r.Jitter
+	}
+	return max(wait, r.MinInterval)
+}
+
+var (
+	lastScanTimestampMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("github_stars", "scan", "last_timestamp_seconds"),
+		"Time when the last scan started",
+		nil, nil,
+	)
+	lastScanDurationMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("github_stars", "scan", "last_duration_seconds"),
+		"Duration of the last scan",
+		nil, nil,
+	)
+	lastScanSuccessMetric = prometheus.NewDesc(
+		prometheus.BuildFQName("github_stars", "scan", "last_success"),
+		"1 if the last scan succeeded, 0 if it failed",
+		nil, nil,
+	)
+)
+
+var _ prometheus.Collector = (*Reconciler)(nil)
+
+// Describe implements prometheus.Collector.
+func (r *Reconciler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lastScanTimestampMetric
+	ch <- lastScanDurationMetric
+	ch <- lastScanSuccessMetric
+}
+
+// Collect implements prometheus.Collector.
+func (r *Reconciler) Collect(ch chan<- prometheus.Metric) {
+	status := r.LastScan()
+	if status.Time.IsZero() {
+		return
+	}
+	var success float64
+	if status.Err == nil {
+		success = 1
+	}
+	ch <- prometheus.MustNewConstMetric(lastScanTimestampMetric, prometheus.GaugeValue, float64(status.Time.Unix()))
+	ch <- prometheus.MustNewConstMetric(lastScanDurationMetric, prometheus.GaugeValue, status.Duration.Seconds())
+	ch <- prometheus.MustNewConstMetric(lastScanSuccessMetric, prometheus.GaugeValue, success)
+}
@@ -0,0 +1,89 @@
+package stars
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconciler_Scan(t *testing.T) {
+	store := newTestStore(t, nil)
+	r := Reconciler{
+		Store:   store,
+		Sources: []Source{{Client: fakeClient{stargazers: []github.Stargazer{{RepoName: "user1/foo", Login: "user1"}}}}},
+	}
+
+	// no scan performed yet: no metrics
+	assert.Zero(t, r.LastScan().Time)
+	assert.Zero(t, testutil.CollectAndCount(&r))
+
+	// successful scan
+	require.NoError(t, r.Scan(t.Context()))
+	status := r.LastScan()
+	assert.NotZero(t, status.Time)
+	assert.NoError(t, status.Err)
+	assert.NoError(t, testutil.CollectAndCompare(&r, strings.NewReader(`
+# HELP github_stars_scan_last_success 1 if the last scan succeeded, 0 if it failed
+# TYPE github_stars_scan_last_success gauge
+github_stars_scan_last_success 1
+`), "github_stars_scan_last_success"))
+
+	// failed scan
+	r.Sources[0].Client = fakeClient{err: errors.New("failed")}
+	require.Error(t, r.Scan(t.Context()))
+	assert.Error(t, r.LastScan().Err)
+	assert.NoError(t, testutil.CollectAndCompare(&r, strings.NewReader(`
+# HELP github_stars_scan_last_success 1 if the last scan succeeded, 0 if it failed
+# TYPE github_stars_scan_last_success gauge
+github_stars_scan_last_success 0
+`), "github_stars_scan_last_success"))
+}
+
+func TestReconciler_Run(t *testing.T) {
+	store := newTestStore(t, nil)
+	var client countingClient
+	r := Reconciler{
+		Store:       store,
+		Sources:     []Source{{Client: &client}},
+		Interval:    10 * time.Millisecond,
+		Jitter:      5 * time.Millisecond,
+		MinInterval: 10 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return client.calls.Load() >= 3 }, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestReconciler_nextScan(t *testing.T) {
+	r := Reconciler{Interval: time.Hour, Jitter: 10 * time.Minute, MinInterval: 55 * time.Minute}
+	for range 100 {
+		next := r.nextScan()
+		assert.GreaterOrEqual(t, next, 55*time.Minute)
+		assert.Less(t, next, 70*time.Minute)
+	}
+}
+
+type countingClient struct {
+	calls atomic.Int32
+}
+
+func (c *countingClient) Stargazers(context.Context, github.Scope) ([]github.Stargazer, error) {
+	c.calls.Add(1)
+	return nil, nil
+}
@@ -0,0 +1,145 @@
+package stars
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/clambin/github-stars/internal/github"
+	"gopkg.in/yaml.v3"
+)
+
+// Routes determine which notifiers receive an event.
+type Routes struct {
+	// Rules are evaluated in order. An event goes to the notifiers of the first rule that matches it.
+	Rules []RouteRule `yaml:"rules"`
+	// Default are the notifiers for events that match no rule.
+	Default []string `yaml:"default"`
+}
+
+// RouteRule matches the events that meet all of its conditions. A blank condition matches all events.
+//
+// Repo, Owner and Topic are glob patterns (see path.Match), e.g. "org/infra-*". A pattern between slashes is
+// a regular expression, e.g. "/^org/(infra|ops)-/".
+type RouteRule struct {
+	// Repo matches the repository's full name
+	Repo string `yaml:"repo"`
+	// Owner matches the user or organization that owns the repository
+	Owner string `yaml:"owner"`
+	// Topic matches any of the repository's topics. Only matches events for which the topics are known.
+	Topic string `yaml:"topic"`
+	// Event matches the kind of event
+	Event MessageKind `yaml:"event"`
+	// Notifiers are the names of the notifiers to send matching events to. If empty, matching events are not sent.
+	Notifiers []string `yaml:"notifiers"`
+}
+
+// LoadRoutes reads Routes from a YAML file.
+func LoadRoutes(filename string) (Routes, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Routes{}, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var routes Routes
+	if err = dec.Decode(&routes); err != nil {
+		return Routes{}, fmt.Errorf("decode %s: %w", filename, err)
+	}
+	return routes, nil
+}
+
+// Router selects the notifiers of an event, according to its Routes.
+type Router struct {
+	rules    []routeRule
+	defaults []string
+}
+
+type routeRule struct {
+	repo, owner, topic matcher
+	event              MessageKind
+	notifiers          []string
+}
+
+// matcher reports whether a value matches a pattern. A nil matcher matches all values.
+type matcher func(string) bool
+
+// NewRouter creates a Router for the Routes.
+func NewRouter(routes Routes) (*Router, error) {
+	r := Router{defaults: routes.Default}
+	for i, rule := range routes.Rules {
+		switch rule.Event {
+		case "", MessageAdded, MessageRemoved, MessageMilestone, MessageDigest:
+		default:
+			return nil, fmt.Errorf("rule %d: invalid event: %q", i+1, rule.Event)
+		}
+		compiled := routeRule{event: rule.Event, notifiers: rule.Notifiers}
+		var err error
+		if compiled.repo, err = newMatcher(rule.Repo); err != nil {
+			return nil, fmt.Errorf("rule %d: repo: %w", i+1, err)
+		}
+		if compiled.owner, err = newMatcher(rule.Owner); err != nil {
+			return nil, fmt.Errorf("rule %d: owner: %w", i+1, err)
+		}
+		if compiled.topic, err = newMatcher(rule.Topic); err != nil {
+			return nil, fmt.Errorf("rule %d: topic: %w", i+1, err)
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return &r, nil
+}
+
+func newMatcher(pattern string) (matcher, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("%q: %w", pattern, err)
+	}
+	return func(s string) bool {
+		ok, _ := path.Match(pattern, s)
+		return ok
+	}, nil
+}
+
+// Route returns the names of the notifiers for an event of the given kind, for the stargazer's repository.
+func (r *Router) Route(kind MessageKind, repo github.Stargazer) []string {
+	for _, rule := range r.rules {
+		if rule.match(kind, repo) {
+			return rule.notifiers
+		}
+	}
+	return r.defaults
+}
+
+func (r routeRule) match(kind MessageKind, repo github.Stargazer) bool {
+	// the names of repositories on other GitHub instances than github.com start with the host.
+	// A repo rule matches both the full name and the name without the host.
+	names := strings.Split(repo.RepoName, "/")
+	owner, name := names[max(0, len(names)-2)], strings.Join(names[max(0, len(names)-2):], "/")
+	return (r.event == "" || r.event == kind) &&
+		(r.repo == nil || r.repo(repo.RepoName) || r.repo(name)) &&
+		(r.owner == nil || r.owner(owner)) &&
+		(r.topic == nil || slices.ContainsFunc(repo.RepoTopics, r.topic))
+}
+
+// Notifiers returns the names of all notifiers that the Router routes events to, in alphabetical order.
+func (r *Router) Notifiers() []string {
+	names := slices.Clone(r.defaults)
+	for _, rule := range r.rules {
+		names = append(names, rule.notifiers...)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
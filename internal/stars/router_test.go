@@ -0,0 +1,97 @@
+package stars
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	r, err := NewRouter(Routes{
+		Rules: []RouteRule{
+			{Repo: "org/infra-*", Notifiers: []string{"slackbot:#infra"}},
+			{Repo: "/^org/(web|api)$/", Event: MessageRemoved, Notifiers: []string{"email"}},
+			{Topic: "exp*", Notifiers: []string{}},
+			{Owner: "me", Notifiers: []string{"slackbot:U123", "email"}},
+		},
+		Default: []string{"slack"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		kind MessageKind
+		repo github.Stargazer
+		want []string
+	}{
+		{name: "repo glob", kind: MessageAdded, repo: github.Stargazer{RepoName: "org/infra-dns"}, want: []string{"slackbot:#infra"}},
+		{name: "glob doesn't match owner", kind: MessageAdded, repo: github.Stargazer{RepoName: "other/infra-dns"}, want: []string{"slack"}},
+		{name: "repo regex and event", kind: MessageRemoved, repo: github.Stargazer{RepoName: "org/api"}, want: []string{"email"}},
+		{name: "event doesn't match", kind: MessageAdded, repo: github.Stargazer{RepoName: "org/api"}, want: []string{"slack"}},
+		{name: "topic", kind: MessageAdded, repo: github.Stargazer{RepoName: "me/foo", RepoTopics: []string{"go", "experimental"}}, want: []string{}},
+		{name: "first match wins", kind: MessageAdded, repo: github.Stargazer{RepoName: "me/foo", RepoTopics: []string{"go"}}, want: []string{"slackbot:U123", "email"}},
+		{name: "repo glob on another GitHub instance", kind: MessageAdded, repo: github.Stargazer{RepoName: "github.example.com/org/infra-dns"}, want: []string{"slackbot:#infra"}},
+		{name: "owner on another GitHub instance", kind: MessageAdded, repo: github.Stargazer{RepoName: "github.example.com/me/foo"}, want: []string{"slackbot:U123", "email"}},
+		{name: "default", kind: MessageMilestone, repo: github.Stargazer{RepoName: "you/foo"}, want: []string{"slack"}},
+		{name: "digest", kind: MessageDigest, want: []string{"slack"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, r.Route(tt.kind, tt.repo))
+		})
+	}
+
+	assert.Equal(t, []string{"email", "slack", "slackbot:#infra", "slackbot:U123"}, r.Notifiers())
+}
+
+func TestNewRouter_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RouteRule
+	}{
+		{name: "invalid glob", rule: RouteRule{Repo: "org/[infra"}},
+		{name: "invalid regex", rule: RouteRule{Owner: "/(org/"}},
+		{name: "invalid topic", rule: RouteRule{Topic: "[go"}},
+		{name: "invalid event", rule: RouteRule{Event: "starred"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRouter(Routes{Rules: []RouteRule{tt.rule}})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - repo: org/infra-*
+    event: added
+    notifiers: [slackbot:#infra]
+  - topic: experimental
+    notifiers: []
+default: [slack, email]
+`), 0600))
+	routes, err := LoadRoutes(path)
+	require.NoError(t, err)
+	assert.Equal(t, Routes{
+		Rules: []RouteRule{
+			{Repo: "org/infra-*", Event: MessageAdded, Notifiers: []string{"slackbot:#infra"}},
+			{Topic: "experimental", Notifiers: []string{}},
+		},
+		Default: []string{"slack", "email"},
+	}, routes)
+
+	// unknown fields are rejected, so typos don't silently route everything elsewhere
+	require.NoError(t, os.WriteFile(path, []byte("rules:\n  - repository: org/infra-*\n"), 0600))
+	_, err = LoadRoutes(path)
+	assert.Error(t, err)
+
+	_, err = LoadRoutes(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
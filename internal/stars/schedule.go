@@ -0,0 +1,148 @@
+package stars
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron schedule: the times that match its minute, hour, day of month, month and day of week.
+// As with cron, if both the day of month and the day of week are restricted (i.e. don't start with "*"), a day
+// matches if either one matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDOM, anyDOW                bool
+}
+
+var scheduleMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+var (
+	scheduleMonths = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	scheduleDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// ParseSchedule parses a cron expression with five fields: minute, hour, day of month, month and day of week,
+// e.g. "0 9 * * MON-FRI". Each field is "*", a value, a range ("1-5") or a list ("1,3,5"), optionally followed
+// by a step ("*/15"). Months and days of the week can also be written as names ("JAN", "MON").
+// ParseSchedule also accepts the macros @hourly, @daily, @midnight, @weekly and @monthly.
+func ParseSchedule(spec string) (Schedule, error) {
+	if macro, ok := scheduleMacros[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: expected 5 fields", spec)
+	}
+	var s Schedule
+	var err error
+	if s.minute, err = parseScheduleField(fields[0], 0, 59, nil); err != nil {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: minute: %w", spec, err)
+	}
+	if s.hour, err = parseScheduleField(fields[1], 0, 23, nil); err != nil {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: hour: %w", spec, err)
+	}
+	if s.dom, err = parseScheduleField(fields[2], 1, 31, nil); err != nil {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: day of month: %w", spec, err)
+	}
+	if s.month, err = parseScheduleField(fields[3], 1, 12, scheduleMonths); err != nil {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: month: %w", spec, err)
+	}
+	// 7 is Sunday too
+	if s.dow, err = parseScheduleField(fields[4], 0, 7, scheduleDays); err != nil {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: day of week: %w", spec, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.anyDOM, s.anyDOW = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseScheduleField returns a bitmask of the values in a field. names, if set, are the names of the values, starting at 0.
+func parseScheduleField(field string, low, high int, names []string) (uint64, error) {
+	var bits uint64
+	for part := range strings.SplitSeq(field, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepSpec); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step: %q", part)
+			}
+		}
+		first, last := low, high
+		if rangeSpec != "*" {
+			from, to, isRange := strings.Cut(rangeSpec, "-")
+			var err error
+			if first, err = parseScheduleValue(from, low, high, names); err != nil {
+				return 0, err
+			}
+			last = first
+			if isRange {
+				if last, err = parseScheduleValue(to, low, high, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				last = high
+			}
+			if first > last {
+				return 0, fmt.Errorf("invalid range: %q", part)
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseScheduleValue(value string, low, high int, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(value, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < low || v > high {
+		return 0, fmt.Errorf("invalid value: %q", value)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that matches the Schedule, in t's location.
+// Next returns the zero time if no time within the next five years matches, e.g. for "0 0 30 2 *".
+func (s Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s Schedule) matchDay(t time.Time) bool {
+	dom, dow := s.dom&(1<<t.Day()) != 0, s.dow&(1<<int(t.Weekday())) != 0
+	if s.anyDOM || s.anyDOW {
+		return dom && dow
+	}
+	return dom || dow
+}
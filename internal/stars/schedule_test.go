@@ -0,0 +1,47 @@
+package stars
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	brussels, err := time.LoadLocation("Europe/Brussels")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{name: "daily", spec: "0 9 * * *", from: time.Date(2025, time.March, 3, 8, 30, 0, 0, time.UTC), want: time.Date(2025, time.March, 3, 9, 0, 0, 0, time.UTC)},
+		{name: "daily, after time", spec: "0 9 * * *", from: time.Date(2025, time.March, 3, 9, 0, 0, 0, time.UTC), want: time.Date(2025, time.March, 4, 9, 0, 0, 0, time.UTC)},
+		{name: "weekly", spec: "30 17 * * FRI", from: time.Date(2025, time.March, 3, 8, 30, 0, 0, time.UTC), want: time.Date(2025, time.March, 7, 17, 30, 0, 0, time.UTC)},
+		{name: "sunday as 7", spec: "0 0 * * 7", from: time.Date(2025, time.March, 3, 8, 30, 0, 0, time.UTC), want: time.Date(2025, time.March, 9, 0, 0, 0, 0, time.UTC)},
+		{name: "step", spec: "*/15 * * * *", from: time.Date(2025, time.March, 3, 8, 31, 10, 0, time.UTC), want: time.Date(2025, time.March, 3, 8, 45, 0, 0, time.UTC)},
+		{name: "range and list", spec: "0 9,18 * * MON-FRI", from: time.Date(2025, time.March, 7, 18, 0, 0, 0, time.UTC), want: time.Date(2025, time.March, 10, 9, 0, 0, 0, time.UTC)},
+		{name: "day of month or week", spec: "0 0 1 * MON", from: time.Date(2025, time.March, 25, 0, 0, 0, 0, time.UTC), want: time.Date(2025, time.March, 31, 0, 0, 0, 0, time.UTC)},
+		{name: "month", spec: "@monthly", from: time.Date(2025, time.December, 25, 0, 0, 0, 0, time.UTC), want: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "time zone", spec: "@daily", from: time.Date(2025, time.March, 3, 8, 30, 0, 0, brussels), want: time.Date(2025, time.March, 4, 0, 0, 0, 0, brussels)},
+		{name: "daylight saving time", spec: "0 9 * * SUN", from: time.Date(2025, time.March, 29, 9, 0, 0, 0, brussels), want: time.Date(2025, time.March, 30, 9, 0, 0, 0, brussels)},
+		{name: "never", spec: "0 0 30 2 *", from: time.Date(2025, time.March, 3, 8, 30, 0, 0, time.UTC), want: time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equal(s.Next(tt.from)), s.Next(tt.from))
+		})
+	}
+}
+
+func TestParseSchedule_Errors(t *testing.T) {
+	for _, spec := range []string{"", "0 9 * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "* * * * FOO", "*/0 * * * *", "5-1 * * * *", "@yearly"} {
+		_, err := ParseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
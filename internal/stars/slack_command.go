@@ -0,0 +1,164 @@
+package stars
+
+import (
+	"cmp"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/slack-go/slack"
+)
+
+const (
+	slackCommandTopRepos         = 10
+	slackCommandRecentStargazers = 5
+)
+
+// SlackCommandHandler returns an HTTP handler for the /stars Slack slash command, which answers questions
+// about the stargazers in the Store:
+//
+//   - /stars top: the repositories with the most stars.
+//   - /stars repo OWNER/NAME: the repository's number of stars and its most recent stargazers.
+//   - /stars who LOGIN: the repositories that the user starred most recently.
+//
+// The handler rejects any request that isn't signed with the Slack app's signing secret.
+func SlackCommandHandler(store Store, signingSecret string, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifier, err := slack.NewSecretsVerifier(r.Header, signingSecret)
+		if err != nil {
+			logger.Warn("invalid Slack command", "err", err)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(io.TeeReader(r.Body, &verifier))
+		cmd, err := slack.SlashCommandParse(r)
+		if err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if err = verifier.Ensure(); err != nil {
+			logger.Warn("invalid Slack command", "err", err)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		text, err := slackCommand(store, cmd.Command, cmd.Text)
+		if err != nil {
+			logger.Error("failed to handle Slack command", "command", cmd.Command, "text", cmd.Text, "err", err)
+			text = "Sorry, something went wrong."
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(slack.Msg{ResponseType: slack.ResponseTypeEphemeral, Text: text})
+	})
+}
+
+// slackCommand returns the answer to a slash command, in Slack's markup.
+func slackCommand(store Store, command, text string) (string, error) {
+	args := strings.Fields(text)
+	switch {
+	case len(args) == 1 && args[0] == "top":
+		return slackCommandTop(store)
+	case len(args) == 2 && args[0] == "repo":
+		return slackCommandRepo(store, args[1])
+	case len(args) == 2 && args[0] == "who":
+		return slackCommandWho(store, args[1])
+	default:
+		return "Usage:\n" +
+			"`" + command + " top`: the repositories with the most stars\n" +
+			"`" + command + " repo owner/name`: the repository's stars and its most recent stargazers\n" +
+			"`" + command + " who login`: the repositories that a user starred", nil
+	}
+}
+
+func slackCommandTop(store Store) (string, error) {
+	stargazers, err := store.Stargazers("")
+	if err != nil {
+		return "", err
+	}
+	repos := stargazersByRepo(stargazers)
+	if len(repos) == 0 {
+		return "No stars yet.", nil
+	}
+	names := make([]string, 0, len(repos))
+	for repo := range repos {
+		names = append(names, repo)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(len(repos[b])-len(repos[a]), strings.Compare(a, b))
+	})
+	var answer strings.Builder
+	for i, repo := range names[:min(len(names), slackCommandTopRepos)] {
+		answer.WriteString(strconv.Itoa(i+1) + ". " + slackRepoLink(repos[repo][0]) + ": " + slackStarCount(len(repos[repo])) + "\n")
+	}
+	return answer.String(), nil
+}
+
+func slackCommandRepo(store Store, repo string) (string, error) {
+	stargazers, err := store.Stargazers(repo)
+	if err != nil {
+		return "", err
+	}
+	if len(stargazers) == 0 {
+		return "No stars for " + markupEscapers[markupSlack](repo) + ".", nil
+	}
+	slices.SortFunc(stargazers, func(a, b github.Stargazer) int {
+		return cmp.Or(b.StarredAt.Compare(a.StarredAt), strings.Compare(a.Login, b.Login))
+	})
+	var answer strings.Builder
+	answer.WriteString(slackRepoLink(stargazers[0]) + " has " + slackStarCount(len(stargazers)) + ". Most recent stargazers:\n")
+	for _, stargazer := range stargazers[:min(len(stargazers), slackCommandRecentStargazers)] {
+		answer.WriteString("• " + slackUserLink(stargazer))
+		if !stargazer.StarredAt.IsZero() {
+			answer.WriteString(" (" + stargazer.StarredAt.UTC().Format("2006-01-02") + ")")
+		}
+		answer.WriteString("\n")
+	}
+	return answer.String(), nil
+}
+
+func slackCommandWho(store Store, login string) (string, error) {
+	stargazers, err := store.Stargazers("")
+	if err != nil {
+		return "", err
+	}
+	stargazers = slices.DeleteFunc(stargazers, func(s github.Stargazer) bool { return !strings.EqualFold(s.Login, login) })
+	if len(stargazers) == 0 {
+		return markupEscapers[markupSlack](login) + " hasn't starred any repositories.", nil
+	}
+	slices.SortFunc(stargazers, func(a, b github.Stargazer) int {
+		return cmp.Or(b.StarredAt.Compare(a.StarredAt), strings.Compare(a.RepoName, b.RepoName))
+	})
+	var answer strings.Builder
+	answer.WriteString(slackUserLink(stargazers[0]) + " starred " + strconv.Itoa(len(stargazers)) + " " + plural(len(stargazers), "repository", "repositories") + ":\n")
+	// like the notifiers, list no more than defaultMaximumUsers
+	for _, stargazer := range stargazers[:min(len(stargazers), defaultMaximumUsers)] {
+		answer.WriteString("• " + slackRepoLink(stargazer) + "\n")
+	}
+	if more := len(stargazers) - defaultMaximumUsers; more > 0 {
+		answer.WriteString("• and " + strconv.Itoa(more) + " more\n")
+	}
+	return answer.String(), nil
+}
+
+func slackRepoLink(stargazer github.Stargazer) string {
+	if stargazer.RepoHTMLURL == "" {
+		return markupEscapers[markupSlack](stargazer.RepoName)
+	}
+	return markupLinks[markupSlack](stargazer.RepoHTMLURL, stargazer.RepoName)
+}
+
+func slackUserLink(stargazer github.Stargazer) string {
+	if stargazer.UserHTMLURL == "" {
+		return markupEscapers[markupSlack](stargazer.Login)
+	}
+	return markupLinks[markupSlack](stargazer.UserHTMLURL, "@"+stargazer.Login)
+}
+
+func slackStarCount(count int) string {
+	return strconv.Itoa(count) + " " + plural(count, "star", "stars")
+}
@@ -0,0 +1,103 @@
+package stars
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlackCommandHandler(t *testing.T) {
+	store, err := NewJSONStore(t.TempDir())
+	require.NoError(t, err)
+	_, err = store.Add(
+		github.Stargazer{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user1", UserHTMLURL: "https://example.com/user1", StarredAt: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		github.Stargazer{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user2", StarredAt: time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		github.Stargazer{RepoName: "foo/baz", Login: "user1", UserHTMLURL: "https://example.com/user1"},
+	)
+	require.NoError(t, err)
+	h := SlackCommandHandler(store, "secret", slog.New(slog.DiscardHandler))
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "top", text: "top", want: "1. <https://example.com/foo/bar|foo/bar>: 2 stars\n2. foo/baz: 1 star\n"},
+		{name: "repo", text: "repo foo/bar", want: "<https://example.com/foo/bar|foo/bar> has 2 stars. Most recent stargazers:\n• user2 (2025-02-01)\n• <https://example.com/user1|@user1> (2025-01-01)\n"},
+		{name: "unknown repo", text: "repo foo/qux", want: "No stars for foo/qux."},
+		{name: "who", text: " who  USER1 ", want: "<https://example.com/user1|@user1> starred 2 repositories:\n• <https://example.com/foo/bar|foo/bar>\n• foo/baz\n"},
+		{name: "unknown user", text: "who <user3>", want: "&lt;user3&gt; hasn't starred any repositories."},
+		{name: "help", text: "", want: "Usage:\n`/stars top`: the repositories with the most stars\n`/stars repo owner/name`: the repository's stars and its most recent stargazers\n`/stars who login`: the repositories that a user starred"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, newSlackCommandRequest(t, "secret", time.Now(), tt.text))
+			require.Equal(t, http.StatusOK, w.Code)
+			var msg slack.Msg
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&msg))
+			assert.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+			assert.Equal(t, tt.want, msg.Text)
+		})
+	}
+}
+
+func TestSlackCommand_Who_Truncated(t *testing.T) {
+	store, err := NewJSONStore(t.TempDir())
+	require.NoError(t, err)
+	for i := range defaultMaximumUsers + 2 {
+		_, err = store.Add(github.Stargazer{RepoName: "fan/repo" + strconv.Itoa(i), Login: "fan"})
+		require.NoError(t, err)
+	}
+	answer, err := slackCommand(store, "/stars", "who fan")
+	require.NoError(t, err)
+	assert.Equal(t, "fan starred 7 repositories:\n• fan/repo0\n• fan/repo1\n• fan/repo2\n• fan/repo3\n• fan/repo4\n• and 2 more\n", answer)
+}
+
+func TestSlackCommandHandler_Signature(t *testing.T) {
+	h := SlackCommandHandler(nil, "secret", slog.New(slog.DiscardHandler))
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{name: "invalid secret", req: newSlackCommandRequest(t, "invalid", time.Now(), "top")},
+		{name: "expired", req: newSlackCommandRequest(t, "secret", time.Now().Add(-time.Hour), "top")},
+		{name: "unsigned", req: httptest.NewRequest(http.MethodPost, "/", strings.NewReader("command=/stars&text=top"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tt.req)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		})
+	}
+}
+
+// newSlackCommandRequest returns a /stars slash command request, signed like Slack does.
+func newSlackCommandRequest(t *testing.T, secret string, timestamp time.Time, text string) *http.Request {
+	t.Helper()
+	body := url.Values{"command": {"/stars"}, "text": {text}}.Encode()
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+
+	req := httptest.NewRequest(http.MethodPost, "/slack/commands", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
@@ -2,33 +2,93 @@ package stars
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 
 	"github.com/clambin/github-stars/internal/github"
 	"github.com/clambin/github-stars/slogctx"
 )
 
 type Client interface {
-	Stargazers(context.Context, string, bool) ([]github.Stargazer, error)
+	Stargazers(context.Context, github.Scope) ([]github.Stargazer, error)
 }
 
-// Scan retrieves all repositories for the user, gets the stars for each repository and adds new ones to the Store.
-func Scan(ctx context.Context, user string, c Client, s *NotifyingStore, includeArchived bool) error {
-	stargazers, err := c.Stargazers(ctx, user, includeArchived)
-	if err != nil {
-		return fmt.Errorf("stars: %w", err)
+// GitHubHost is the hostname of github.com.
+const GitHubHost = "github.com"
+
+// Source is a GitHub instance to scan for stargazers.
+type Source struct {
+	// Name is the hostname of the GitHub instance. It identifies the Source in logs and error messages.
+	// Unless Name is GitHubHost, the Source's repositories are stored under their name prefixed by Name
+	// (e.g. github.example.com/org/repo), so they don't clash with repositories of the same name on github.com.
+	Name   string
+	Client Client
+	Scope  github.Scope
+}
+
+// owns reports whether the stargazer belongs to one of the Source's repositories.
+func (s Source) owns(stargazer github.Stargazer) bool {
+	if s.Name == GitHubHost {
+		return strings.Count(stargazer.RepoName, "/") == 1
+	}
+	return strings.HasPrefix(stargazer.RepoName, s.Name+"/")
+}
+
+// qualify prefixes the stargazers' repository names with the host of their GitHub instance, unless it's github.com.
+func qualify(host string, stargazers ...github.Stargazer) []github.Stargazer {
+	if host == GitHubHost {
+		return stargazers
+	}
+	qualified := make([]github.Stargazer, len(stargazers))
+	for i, stargazer := range stargazers {
+		stargazer.RepoName = host + "/" + stargazer.RepoName
+		qualified[i] = stargazer
 	}
+	return qualified
+}
 
-	if err = s.Set(ctx, stargazers); err != nil {
-		return fmt.Errorf("add: %w", err)
+// Scan retrieves all repositories in scope of each Source, gets the stars for each repository and updates the Store accordingly.
+//
+// If a Source fails, Scan keeps its stargazers as they are in the Store and still updates those of the other Sources.
+// Without any Source, Scan returns an error, rather than removing all stargazers from the Store.
+func Scan(ctx context.Context, sources []Source, s *NotifyingStore) error {
+	if len(sources) == 0 {
+		return errors.New("no sources")
+	}
+	var errs []error
+	err := s.Reconcile(ctx, func(ctx context.Context) ([]github.Stargazer, error) {
+		var stargazers []github.Stargazer
+		for _, source := range sources {
+			sourceStargazers, err := source.Client.Stargazers(ctx, source.Scope)
+			if err == nil {
+				stargazers = append(stargazers, qualify(source.Name, sourceStargazers...)...)
+				continue
+			}
+			errs = append(errs, fmt.Errorf("stars %s: %w", source.Name, err))
+			current, err := s.Store.Stargazers("")
+			if err != nil {
+				return nil, fmt.Errorf("stargazers: %w", err)
+			}
+			stargazers = append(stargazers, slices.DeleteFunc(current, func(star github.Stargazer) bool { return !source.owns(star) })...)
+		}
+		if len(errs) == len(sources) {
+			return nil, errors.Join(errs...)
+		}
+		return stargazers, nil
+	})
+	if err != nil {
+		return err
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
-// Handler returns a webhook handler for GitHub star events.
-func Handler(store *NotifyingStore) func(ctx context.Context, stargazer github.Stargazer) error {
+// Handler returns a webhook handler for GitHub star events received from the GitHub instance at host.
+func Handler(store *NotifyingStore, host string) func(ctx context.Context, stargazer github.Stargazer) error {
 	return func(ctx context.Context, stargazer github.Stargazer) (err error) {
+		stargazer = qualify(host, stargazer)[0]
 		// Get logger
 		logger := slogctx.FromContext(ctx).With(
 			slog.String("repo", stargazer.RepoName),
@@ -3,8 +3,10 @@ package stars
 import (
 	"bytes"
 	"context"
+	"errors"
 	"log/slog"
 	"net/http/httptest"
+	"slices"
 	"testing"
 	"time"
 
@@ -15,22 +17,63 @@ import (
 )
 
 func TestScan(t *testing.T) {
-	c := fakeClient{
-		stargazers: []github.Stargazer{
+	sources := []Source{
+		{Name: "github.com", Client: fakeClient{stargazers: []github.Stargazer{
 			{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "user1/foo", Login: "user1"},
+		}}},
+		{Name: "github.example.com", Client: fakeClient{stargazers: []github.Stargazer{
 			{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "user1/bar", Login: "user1"},
-		},
+		}}},
 	}
 
 	var buf bytes.Buffer
 	ctx := slogctx.NewWithContext(t.Context(), slogWithoutTime(&buf, slog.LevelInfo))
-	store, err := NewNotifyingStore(t.TempDir(), Notifiers{SlogNotifier{}})
-	require.NoError(t, err)
+	store := newTestStore(t, Notifiers{SlogNotifier{}})
 
-	require.NoError(t, Scan(ctx, "user1", &c, store, false))
+	require.NoError(t, Scan(ctx, sources, store))
 
 	assert.Contains(t, buf.String(), "level=INFO msg=\"repo has 1 new stargazers\" repo=user1/foo\n")
-	assert.Contains(t, buf.String(), "level=INFO msg=\"repo has 1 new stargazers\" repo=user1/bar\n")
+	assert.Contains(t, buf.String(), "level=INFO msg=\"repo has 1 new stargazers\" repo=github.example.com/user1/bar\n")
+
+	// a repository with the same name on another GitHub instance is a different repository
+	sources[1].Client = fakeClient{stargazers: []github.Stargazer{
+		{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "user1/foo", Login: "user2"},
+	}}
+	require.NoError(t, Scan(ctx, sources, store))
+	stargazers, err := store.Stargazers("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"github.example.com/user1/foo/user2", "user1/foo/user1"}, stargazerNames(stargazers))
+
+	// if one source fails, the other sources are still reconciled
+	sources[0].Client = fakeClient{}
+	sources[1].Client = fakeClient{err: errors.New("fail")}
+	assert.Error(t, Scan(ctx, sources, store))
+	stargazers, err = store.Stargazers("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"github.example.com/user1/foo/user2"}, stargazerNames(stargazers))
+
+	// if all sources fail, the store is left untouched
+	sources[0].Client = fakeClient{err: errors.New("fail")}
+	assert.Error(t, Scan(ctx, sources, store))
+	stargazers, err = store.Stargazers("")
+	require.NoError(t, err)
+	assert.Len(t, stargazers, 1)
+
+	// without sources, the store is left untouched
+	assert.Error(t, Scan(ctx, nil, store))
+	stargazers, err = store.Stargazers("")
+	require.NoError(t, err)
+	assert.Len(t, stargazers, 1)
+}
+
+// stargazerNames returns the repository and login of each stargazer, in alphabetical order.
+func stargazerNames(stargazers []github.Stargazer) []string {
+	names := make([]string, len(stargazers))
+	for i, star := range stargazers {
+		names[i] = star.RepoName + "/" + star.Login
+	}
+	slices.Sort(names)
+	return names
 }
 
 func TestHandler(t *testing.T) {
@@ -42,9 +85,8 @@ func TestHandler(t *testing.T) {
 		SlogNotifier{},
 		SlackNotifier{WebHookURL: ts.URL},
 	}
-	store, err := NewNotifyingStore(t.TempDir(), notifiers)
-	require.NoError(t, err)
-	h := Handler(store)
+	store := newTestStore(t, notifiers)
+	h := Handler(store, GitHubHost)
 
 	var logBuf bytes.Buffer
 	ctx := slogctx.New(slogWithoutTime(&logBuf, slog.LevelInfo))
@@ -79,8 +121,9 @@ var _ Client = fakeClient{}
 
 type fakeClient struct {
 	stargazers []github.Stargazer
+	err        error
 }
 
-func (f fakeClient) Stargazers(context.Context, string, bool) ([]github.Stargazer, error) {
-	return f.stargazers, nil
+func (f fakeClient) Stargazers(context.Context, github.Scope) ([]github.Stargazer, error) {
+	return f.stargazers, f.err
 }
@@ -3,12 +3,10 @@ package stars
 import (
 	"cmp"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
-	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
 
@@ -17,117 +15,23 @@ import (
 	"github.com/slack-go/slack"
 )
 
-const StoreFilename = "stargazers.json"
-
-// Store contains all stars for all repositories
-type Store struct {
-	stargazers   map[string]map[string]github.Stargazer
-	databasePath string
-	lock         sync.RWMutex
-}
-
-// NewStore creates a new Store
-func NewStore(databasePath string) (store *Store, err error) {
-	store = &Store{databasePath: databasePath}
-	f, err := os.Open(filepath.Join(databasePath, StoreFilename))
-	switch {
-	case err == nil:
-		defer func() { _ = f.Close() }()
-		var stargazers []github.Stargazer
-		if err = json.NewDecoder(f).Decode(&stargazers); err != nil {
-			return nil, fmt.Errorf("decode: %w", err)
-		}
-		if len(stargazers) > 0 {
-			store.stargazers = indexedStargazers(stargazers)
-		}
-	case os.IsNotExist(err):
-		store.stargazers = make(map[string]map[string]github.Stargazer)
-		err = nil
-	default:
-		return nil, err
-	}
-	return store, err
-}
-
-// save saves the store to disk
-func (s *Store) save() error {
-	var stargazers []github.Stargazer
-	for _, users := range s.stargazers {
-		for _, rs := range users {
-			stargazers = append(stargazers, rs)
-		}
-	}
-
-	f, err := os.Create(filepath.Join(s.databasePath, "stargazers.json"))
-	if err != nil {
-		return fmt.Errorf("open: %w", err)
-	}
-	defer func() { _ = f.Close() }()
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	if err = enc.Encode(stargazers); err != nil {
-		_ = f.Close()
-		return fmt.Errorf("encode: %w", err)
-	}
-	return f.Close()
-}
-
-// Add adds new stargazers to a repository.
-// Returns the new stargazers and an error if there was a problem saving the store to disk.
-func (s *Store) Add(stargazers ...github.Stargazer) ([]github.Stargazer, error) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	added := make([]github.Stargazer, 0, len(stargazers))
-	for _, star := range stargazers {
-		if _, ok := s.stargazers[star.RepoName]; !ok {
-			s.stargazers[star.RepoName] = make(map[string]github.Stargazer)
-		}
-		if _, ok := s.stargazers[star.RepoName][star.Login]; !ok {
-			s.stargazers[star.RepoName][star.Login] = star
-			added = append(added, star)
-		}
-	}
-	var err error
-	if len(added) > 0 {
-		err = s.save()
-	}
-	return added, err
-}
-
-// Delete removes stargazers from a repository.
-// Returns the removed stargazers and an error if there was a problem saving the store to disk.
-func (s *Store) Delete(stargazer ...github.Stargazer) ([]github.Stargazer, error) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	removed := make([]github.Stargazer, 0, len(stargazer))
-	for _, star := range stargazer {
-		if _, ok := s.stargazers[star.RepoName]; !ok {
-			continue
-		}
-		if _, ok := s.stargazers[star.RepoName][star.Login]; !ok {
-			continue
-		}
-		delete(s.stargazers[star.RepoName], star.Login)
-		removed = append(removed, star)
-	}
-	return removed, s.save()
-}
-
-// Set updates the store to exactly match the provided stargazers per repository.
-// It returns the stargazers that were added and those that were removed.
-func (s *Store) Set(stargazers []github.Stargazer) ([]github.Stargazer, []github.Stargazer, error) {
-	// Build desired state: repo -> login -> RepoStar
-	desired := indexedStargazers(stargazers)
-	added := repoDiff(desired, s.stargazers)
-	removed := repoDiff(s.stargazers, desired)
-
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	s.stargazers = desired
-	if err := s.save(); err != nil {
-		return nil, nil, err
-	}
-	return added, removed, nil
+// Store holds the stargazers of all repositories.
+type Store interface {
+	// Add adds stargazers to the Store. It returns the stargazers that were not yet in the Store.
+	Add(stargazers ...github.Stargazer) ([]github.Stargazer, error)
+	// Delete removes stargazers from the Store. It returns the stargazers that were in the Store.
+	Delete(stargazers ...github.Stargazer) ([]github.Stargazer, error)
+	// Set updates the Store to exactly match the provided stargazers. It returns the stargazers that were added and removed.
+	Set(stargazers []github.Stargazer) ([]github.Stargazer, []github.Stargazer, error)
+	// Stargazers returns the stargazers of a repository, ordered by login. If repo is blank, it returns the stargazers
+	// of all repositories, ordered by repository and login.
+	Stargazers(repo string) ([]github.Stargazer, error)
+	// AddEvents appends Events to the Store's event history.
+	AddEvents(events ...Event) error
+	// Events returns the Events in the event history that match the filter, in the order they were added.
+	Events(filter EventFilter) ([]Event, error)
+	// Close releases any resources held by the Store.
+	Close() error
 }
 
 // indexedStargazers indexes the stargazers by repository and user.
@@ -142,6 +46,24 @@ func indexedStargazers(stargazers []github.Stargazer) map[string]map[string]gith
 	return index
 }
 
+// flattenedStargazers returns all stargazers in an index.
+func flattenedStargazers(index map[string]map[string]github.Stargazer) []github.Stargazer {
+	var stargazers []github.Stargazer
+	for _, users := range index {
+		for _, star := range users {
+			stargazers = append(stargazers, star)
+		}
+	}
+	return stargazers
+}
+
+// sortStargazers sorts stargazers by repository and login.
+func sortStargazers(stargazers []github.Stargazer) {
+	slices.SortFunc(stargazers, func(a, b github.Stargazer) int {
+		return cmp.Or(strings.Compare(a.RepoName, b.RepoName), strings.Compare(a.Login, b.Login))
+	})
+}
+
 // repoDiff returns the stargazers from a that are not in b.
 func repoDiff(a, b map[string]map[string]github.Stargazer) []github.Stargazer {
 	var diff []github.Stargazer
@@ -158,76 +80,268 @@ func repoDiff(a, b map[string]map[string]github.Stargazer) []github.Stargazer {
 
 // NotifyingStore is a Store that notifies a Notifier when stargazers are added or removed.
 type NotifyingStore struct {
-	*Store
+	Store
 	Notifiers
+	// Milestones tracks the milestones that the repositories reached. If set, NotifyingStore notifies
+	// the Notifiers when a repository reaches a new milestone.
+	Milestones *MilestoneTracker
+	// journal records the stargazers added or deleted while a Reconcile is in progress. nil if no Reconcile is running.
+	journal []github.Stargazer
+	// topics holds the topics of each repository, as last received from a scan or a webhook call.
+	// The Store doesn't keep the topics, so they are added to the stargazers here before notifying.
+	topics     map[string][]string
+	updateLock sync.Mutex
+	scanLock   sync.Mutex
 }
 
-// NewNotifyingStore creates a new NotifyingStore.
-func NewNotifyingStore(databaseDirectory string, notifiers Notifiers) (*NotifyingStore, error) {
-	store, err := NewStore(databaseDirectory)
-	if err != nil {
-		return nil, err
-	}
+// NewNotifyingStore creates a new NotifyingStore for the Store.
+func NewNotifyingStore(store Store, notifiers Notifiers) *NotifyingStore {
 	return &NotifyingStore{
 		Store:     store,
 		Notifiers: notifiers,
-	}, nil
+	}
 }
 
-// Add adds new stargazers to a repository.
+// Add adds new stargazers to a repository, as received through a webhook.
 // Notifies the Notifier if there were any new stargazers.
-func (s NotifyingStore) Add(ctx context.Context, stars ...github.Stargazer) error {
+func (s *NotifyingStore) Add(ctx context.Context, stars ...github.Stargazer) error {
+	s.updateLock.Lock()
 	added, err := s.Store.Add(stars...)
-	if err == nil && len(added) > 0 {
-		s.Notify(ctx, true, added)
-	}
-	return err
+	s.record("created", stars)
+	s.setTopics(stars)
+	added = s.withTopics(added)
+	counts, countErr := s.starCounts(added, err)
+	s.updateLock.Unlock()
+	return errors.Join(s.notifyChanges(ctx, SourceWebhook, added, nil, counts, err), countErr)
 }
 
-// Delete removes stargazers from a repository.
+// Delete removes stargazers from a repository, as received through a webhook.
 // Notifies the Notifier if there were any removed stargazers.
-func (s NotifyingStore) Delete(ctx context.Context, stars ...github.Stargazer) error {
+func (s *NotifyingStore) Delete(ctx context.Context, stars ...github.Stargazer) error {
+	s.updateLock.Lock()
 	deleted, err := s.Store.Delete(stars...)
-	if err == nil && len(deleted) > 0 {
-		s.Notify(ctx, false, deleted)
-	}
-	return err
+	s.record("deleted", stars)
+	s.setTopics(stars)
+	deleted = s.withTopics(deleted)
+	s.updateLock.Unlock()
+	return s.notifyChanges(ctx, SourceWebhook, nil, deleted, nil, err)
 }
 
-// Set updates the store to the provided stargazers.
+// Set updates the store to the provided stargazers, as found by a scan.
 // Notifies the Notifier if there were any new or removed stargazers.
-func (s NotifyingStore) Set(ctx context.Context, stars []github.Stargazer) error {
+func (s *NotifyingStore) Set(ctx context.Context, stars []github.Stargazer) error {
+	s.updateLock.Lock()
 	added, deleted, err := s.Store.Set(stars)
-	if err == nil && len(added) > 0 {
-		s.Notify(ctx, true, added)
+	s.setTopics(stars)
+	added, deleted = s.withTopics(added), s.withTopics(deleted)
+	counts, countErr := s.starCounts(added, err)
+	s.updateLock.Unlock()
+	return errors.Join(s.notifyChanges(ctx, SourceScan, added, deleted, counts, err), countErr)
+}
+
+// Reconcile updates the store to the stargazers returned by fetch. Only one Reconcile runs at a time.
+//
+// fetch may take a long time to complete. Any stargazers added or deleted through Add and Delete in the meantime
+// are applied on top of fetch's result, so Reconcile never undoes a change that fetch did not see.
+func (s *NotifyingStore) Reconcile(ctx context.Context, fetch func(context.Context) ([]github.Stargazer, error)) error {
+	s.scanLock.Lock()
+	defer s.scanLock.Unlock()
+
+	s.updateLock.Lock()
+	s.journal = make([]github.Stargazer, 0)
+	s.updateLock.Unlock()
+
+	stars, err := fetch(ctx)
+
+	s.updateLock.Lock()
+	journal := s.journal
+	s.journal = nil
+	if err != nil {
+		s.updateLock.Unlock()
+		return err
+	}
+	added, deleted, err := s.Store.Set(replayJournal(stars, journal))
+	s.setTopics(stars)
+	added, deleted = s.withTopics(added), s.withTopics(deleted)
+	counts, countErr := s.starCounts(added, err)
+	s.updateLock.Unlock()
+	if err != nil {
+		err = fmt.Errorf("set: %w", err)
+	}
+	return errors.Join(s.notifyChanges(ctx, SourceScan, added, deleted, counts, err), countErr)
+}
+
+// record adds the stargazers to the journal, if a Reconcile is in progress. Caller must hold updateLock.
+func (s *NotifyingStore) record(action string, stars []github.Stargazer) {
+	if s.journal == nil {
+		return
+	}
+	for _, star := range stars {
+		star.Action = action
+		s.journal = append(s.journal, star)
+	}
+}
+
+// setTopics records the topics of the stargazers' repositories. Caller must hold updateLock.
+func (s *NotifyingStore) setTopics(stars []github.Stargazer) {
+	if s.topics == nil {
+		s.topics = make(map[string][]string)
+	}
+	for _, star := range stars {
+		s.topics[star.RepoName] = star.RepoTopics
+	}
+}
+
+// withTopics returns the stargazers with the topics of their repository, as recorded by setTopics.
+// Caller must hold updateLock.
+func (s *NotifyingStore) withTopics(stars []github.Stargazer) []github.Stargazer {
+	if len(stars) == 0 {
+		return stars
+	}
+	out := make([]github.Stargazer, len(stars))
+	for i, star := range stars {
+		star.RepoTopics = s.topics[star.RepoName]
+		out[i] = star
+	}
+	return out
+}
+
+// starCounts returns the number of stargazers of each repository that gained stargazers, for the Milestones.
+// updateErr is the outcome of the update. Caller must hold updateLock, so the counts are those right after the update.
+func (s *NotifyingStore) starCounts(added []github.Stargazer, updateErr error) (map[string]int, error) {
+	if s.Milestones == nil || updateErr != nil || len(added) == 0 {
+		return nil, nil
+	}
+	counts := make(map[string]int)
+	var errs []error
+	for repo := range stargazersByRepo(added) {
+		stargazers, err := s.Stargazers(repo)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("milestones: %w", err))
+			continue
+		}
+		counts[repo] = len(stargazers)
+	}
+	return counts, errors.Join(errs...)
+}
+
+// notifyChanges records the added and deleted stargazers in the event history and notifies the Notifiers.
+// counts holds the number of stargazers of the repositories after the update, as returned by starCounts.
+// err is the outcome of the update: if the update failed, notifyChanges does nothing and returns err.
+func (s *NotifyingStore) notifyChanges(ctx context.Context, source EventSource, added, deleted []github.Stargazer, counts map[string]int, err error) error {
+	if err != nil {
+		return err
+	}
+	events := append(newEvents(EventStarred, source, added), newEvents(EventUnstarred, source, deleted)...)
+	if len(events) > 0 {
+		if err = s.AddEvents(events...); err != nil {
+			// the stargazers have been updated, so still notify
+			err = fmt.Errorf("events: %w", err)
+		}
+	}
+	if len(added) > 0 {
+		if notifyErr := s.Notify(ctx, true, added); notifyErr != nil {
+			err = errors.Join(err, fmt.Errorf("notify: %w", notifyErr))
+		}
 	}
-	if err == nil && len(deleted) > 0 {
-		s.Notify(ctx, false, deleted)
+	if len(deleted) > 0 {
+		if notifyErr := s.Notify(ctx, false, deleted); notifyErr != nil {
+			err = errors.Join(err, fmt.Errorf("notify: %w", notifyErr))
+		}
+	}
+	if s.Milestones != nil && len(added) > 0 {
+		err = errors.Join(err, s.notifyMilestones(ctx, source, added, deleted, counts))
 	}
 	return err
 }
 
-// Notifier notifies about added/removed stargazers.
+// notifyMilestones notifies the Notifiers of the repositories that reached a new milestone, after the stargazers
+// were added and deleted. counts holds the repositories' number of stargazers after the update.
+func (s *NotifyingStore) notifyMilestones(ctx context.Context, source EventSource, added, deleted []github.Stargazer, counts map[string]int) error {
+	removed := stargazersByRepo(deleted)
+	var errs []error
+	for repo, repoAdded := range stargazersByRepo(added) {
+		count, ok := counts[repo]
+		if !ok {
+			continue
+		}
+		milestone, err := s.Milestones.update(repo, count-len(repoAdded)+len(removed[repo]), count, source)
+		if err != nil {
+			// the milestone is recorded in memory, so still notify
+			errs = append(errs, fmt.Errorf("milestones: %w", err))
+		}
+		if milestone > 0 {
+			if notifyErr := s.NotifyMilestone(ctx, repoDetails(repoAdded[0]), milestone); notifyErr != nil {
+				errs = append(errs, fmt.Errorf("notify: %w", notifyErr))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// repoDetails returns the repository's details from one of its stargazers.
+func repoDetails(stargazer github.Stargazer) github.Stargazer {
+	return github.Stargazer{RepoName: stargazer.RepoName, RepoHTMLURL: stargazer.RepoHTMLURL, RepoTopics: stargazer.RepoTopics}
+}
+
+// replayJournal applies the journaled changes, in order, to the stargazers.
+func replayJournal(stargazers []github.Stargazer, journal []github.Stargazer) []github.Stargazer {
+	if len(journal) == 0 {
+		return stargazers
+	}
+	index := indexedStargazers(stargazers)
+	for _, star := range journal {
+		action := star.Action
+		star.Action = ""
+		switch action {
+		case "created":
+			if _, ok := index[star.RepoName]; !ok {
+				index[star.RepoName] = make(map[string]github.Stargazer)
+			}
+			if _, ok := index[star.RepoName][star.Login]; !ok {
+				index[star.RepoName][star.Login] = star
+			}
+		case "deleted":
+			delete(index[star.RepoName], star.Login)
+		}
+	}
+	return flattenedStargazers(index)
+}
+
+// Notifier notifies about added/removed stargazers. Notify returns an error if the notification could not be delivered.
 type Notifier interface {
-	Notify(ctx context.Context, added bool, stars []github.Stargazer)
+	Notify(ctx context.Context, added bool, stars []github.Stargazer) error
 }
 
 // Notifiers is a collection of Notifiers.
 type Notifiers []Notifier
 
-// Notify notifies all Notifiers.
-func (n Notifiers) Notify(ctx context.Context, added bool, stars []github.Stargazer) {
+// Notify notifies all Notifiers. A failing Notifier does not prevent the others from being notified.
+func (n Notifiers) Notify(ctx context.Context, added bool, stars []github.Stargazer) error {
+	errs := make([]error, 0, len(n))
 	for _, notifier := range n {
-		notifier.Notify(ctx, added, stars)
+		errs = append(errs, notifier.Notify(ctx, added, stars))
 	}
+	return errors.Join(errs...)
+}
+
+// NotifyMilestone notifies all Notifiers that are a MilestoneNotifier.
+func (n Notifiers) NotifyMilestone(ctx context.Context, repo github.Stargazer, milestone int) error {
+	errs := make([]error, 0, len(n))
+	for _, notifier := range n {
+		if m, ok := notifier.(MilestoneNotifier); ok {
+			errs = append(errs, m.NotifyMilestone(ctx, repo, milestone))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // SlogNotifier is a Notifier that logs the added/removed stargazers to a slog.Logger stored in the context.
 type SlogNotifier struct{}
 
-var _ Notifier = SlogNotifier{}
+var _ MilestoneNotifier = SlogNotifier{}
 
-func (s SlogNotifier) Notify(ctx context.Context, added bool, stars []github.Stargazer) {
+func (s SlogNotifier) Notify(ctx context.Context, added bool, stars []github.Stargazer) error {
 	logger := slogctx.FromContext(ctx)
 	for repo, repoStars := range stargazersByRepo(stars) {
 		var msg string
@@ -239,6 +353,12 @@ func (s SlogNotifier) Notify(ctx context.Context, added bool, stars []github.Sta
 		}
 		logger.Info(msg, slog.String("repo", repo))
 	}
+	return nil
+}
+
+func (s SlogNotifier) NotifyMilestone(ctx context.Context, repo github.Stargazer, milestone int) error {
+	slogctx.FromContext(ctx).Info("repo reached a milestone", slog.String("repo", repo.RepoName), slog.Int("stars", milestone))
+	return nil
 }
 
 const (
@@ -247,6 +367,8 @@ const (
 
 // SlackNotifier is a Notifier that posts added/removed stargazers to a Slack channel.
 type SlackNotifier struct {
+	// Templates renders the messages. If nil, SlackNotifier posts the default messages.
+	Templates *Templates
 	// WebHookURL is the URL to the Slack webhook
 	WebHookURL string
 	// MaximumUsers is the maximum number of users to notify about. Default is 5.
@@ -254,18 +376,26 @@ type SlackNotifier struct {
 	MaximumUsers int
 }
 
-var _ Notifier = SlackNotifier{}
+var (
+	_ MilestoneNotifier = SlackNotifier{}
+	_ DigestNotifier    = SlackNotifier{}
+)
 
-func (s SlackNotifier) Notify(ctx context.Context, added bool, stars []github.Stargazer) {
+func (s SlackNotifier) Notify(ctx context.Context, added bool, stars []github.Stargazer) error {
+	var errs []error
 	for _, stargazers := range stargazersByRepo(stars) {
-		err := slack.PostWebhook(s.WebHookURL, &slack.WebhookMessage{
-			Text:        s.makeMessage(stargazers, added),
-			UnfurlLinks: false,
-		})
+		text, err := s.makeMessage(stargazers, added)
+		if err == nil {
+			err = slack.PostWebhookContext(ctx, s.WebHookURL, &slack.WebhookMessage{
+				Text:        text,
+				UnfurlLinks: false,
+			})
+		}
 		if err != nil {
-			slogctx.FromContext(ctx).Warn("Failed to post message", "err", err)
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
 }
 
 var action = map[bool]string{
@@ -273,36 +403,24 @@ var action = map[bool]string{
 	false: "lost",
 }
 
-func (s SlackNotifier) makeMessage(gazers []github.Stargazer, added bool) string {
-	// Guard against empty input (shouldn't normally happen)
-	if len(gazers) == 0 {
-		return ""
+func (s SlackNotifier) NotifyMilestone(ctx context.Context, repo github.Stargazer, milestone int) error {
+	text, err := s.Templates.render("slack", MessageMilestone, markupSlack, newMilestoneData(repo, milestone))
+	if err != nil {
+		return err
 	}
+	return slack.PostWebhookContext(ctx, s.WebHookURL, &slack.WebhookMessage{Text: text, UnfurlLinks: false})
+}
 
-	// Determine repo information from the first stargazer
-	repoName := slackFormatRepo(gazers[0])
-
-	// maximum users to list individually
-	maxUsers := cmp.Or(s.MaximumUsers, defaultMaximumUsers)
-
-	// Create the userList text depending on the number of gazers
-	var userList string
-	if len(gazers) > 1 {
-		userList = strconv.Itoa(len(gazers)) + " users"
-	}
-	if len(gazers) <= maxUsers {
-		if len(gazers) > 1 {
-			userList += ": "
-		}
-		// Build list of user mentions
-		users := make([]string, len(gazers))
-		for i := range gazers {
-			users[i] = slackFormatUser(gazers[i])
-		}
-		userList += strings.Join(users, ", ")
+func (s SlackNotifier) NotifyDigest(ctx context.Context, digest Digest) error {
+	text, err := s.Templates.render("slack", MessageDigest, markupSlack, newDigestData(digest))
+	if err != nil {
+		return err
 	}
+	return slack.PostWebhookContext(ctx, s.WebHookURL, &slack.WebhookMessage{Text: text, UnfurlLinks: false})
+}
 
-	return "Repo " + repoName + " " + action[added] + " a star from " + userList
+func (s SlackNotifier) makeMessage(gazers []github.Stargazer, added bool) (string, error) {
+	return s.Templates.render("slack", messageKind(added), markupSlack, newMessageData(gazers, added, cmp.Or(s.MaximumUsers, defaultMaximumUsers)))
 }
 
 func stargazersByRepo(stargazer []github.Stargazer) map[string][]github.Stargazer {
@@ -312,17 +430,3 @@ func stargazersByRepo(stargazer []github.Stargazer) map[string][]github.Stargaze
 	}
 	return out
 }
-
-func slackFormatRepo(stargazer github.Stargazer) string {
-	if repoHTMLURL := stargazer.RepoHTMLURL; repoHTMLURL != "" {
-		return "<" + repoHTMLURL + "|" + stargazer.RepoName + ">"
-	}
-	return stargazer.RepoName
-}
-
-func slackFormatUser(stargazer github.Stargazer) string {
-	if userHTMLURL := stargazer.UserHTMLURL; userHTMLURL != "" {
-		return "<" + userHTMLURL + "|@" + stargazer.Login + ">"
-	}
-	return stargazer.Login
-}
@@ -0,0 +1,348 @@
+package stars
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/clambin/github-stars/internal/github"
+)
+
+const (
+	// StoreFilename is the name of the file holding the stargazers of a JSONStore.
+	StoreFilename = "stargazers.json"
+	// EventsFilename is the name of the file holding the event history of a JSONStore, one JSON-encoded Event per line.
+	EventsFilename = "events.jsonl"
+)
+
+// JSONStoreBackups is the number of previous versions of the database that a JSONStore keeps.
+const JSONStoreBackups = 3
+
+// JSONStore is a Store that keeps all stargazers in memory and saves them to a JSON file on every change.
+// Events are appended to a separate file, which is never rewritten.
+//
+// JSONStore never overwrites the database in place: it writes a new version to a temporary file and renames it
+// over the database. The previous versions are kept as backups (stargazers.json.1 being the most recent one).
+// If the database is corrupt, NewJSONStore restores the most recent valid backup.
+type JSONStore struct {
+	stargazers    map[string]map[string]github.Stargazer
+	databasePath  string
+	recoveredFrom string
+	lock          sync.RWMutex
+	eventsLock    sync.Mutex
+}
+
+var _ Store = (*JSONStore)(nil)
+
+// NewJSONStore creates a new JSONStore, loading any stargazers saved in the database directory.
+// If the database was written by an older version, NewJSONStore upgrades it, keeping the original as stargazers.json.v<version>.
+// If the database can't be loaded, NewJSONStore restores the most recent valid backup.
+func NewJSONStore(databasePath string) (*JSONStore, error) {
+	store := JSONStore{databasePath: databasePath, stargazers: make(map[string]map[string]github.Stargazer)}
+	stargazers, version, err := loadStargazers(store.path())
+	if err == nil {
+		store.stargazers = indexedStargazers(stargazers)
+		if version < jsonVersion {
+			if err = copyFile(store.path(), store.path()+".v"+strconv.Itoa(version)); err != nil {
+				return nil, fmt.Errorf("backup: %w", err)
+			}
+			if err = store.write(); err != nil {
+				return nil, fmt.Errorf("migrate: %w", err)
+			}
+		}
+		return &store, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return &store, nil
+	}
+	// a database written by a newer version isn't corrupt: don't replace it by an older backup
+	if errors.Is(err, errUnsupportedVersion) {
+		return nil, err
+	}
+	for i := 1; i <= JSONStoreBackups; i++ {
+		backup := backupPath(store.path(), i)
+		if stargazers, _, backupErr := loadStargazers(backup); backupErr == nil {
+			store.stargazers = indexedStargazers(stargazers)
+			store.recoveredFrom = backup
+			// don't rotate the backups: that would replace a valid backup by the corrupt database
+			if err = store.write(); err != nil {
+				return nil, fmt.Errorf("restore %s: %w", backup, err)
+			}
+			return &store, nil
+		}
+	}
+	return nil, fmt.Errorf("load: %w", err)
+}
+
+// RecoveredFrom returns the backup that NewJSONStore restored the database from.
+// Returns a blank string if the database was loaded without restoring a backup.
+func (s *JSONStore) RecoveredFrom() string {
+	return s.recoveredFrom
+}
+
+func (s *JSONStore) path() string {
+	return filepath.Join(s.databasePath, StoreFilename)
+}
+
+// backupPath returns the path of the nth backup of the database.
+func backupPath(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// loadStargazers reads the stargazers from a database file. It also returns the version of the database file.
+func loadStargazers(path string) ([]github.Stargazer, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, version, err := migrateJSON(data)
+	if err != nil {
+		return nil, version, fmt.Errorf("%s: %w", path, err)
+	}
+	var db jsonDatabase
+	if err = json.Unmarshal(data, &db); err != nil {
+		return nil, version, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return db.Stargazers, version, nil
+}
+
+// save saves the store to disk, keeping the previous version as a backup.
+func (s *JSONStore) save() error {
+	if err := s.rotateBackups(); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return s.write()
+}
+
+// rotateBackups shifts all backups up by one and makes the current database the most recent backup.
+func (s *JSONStore) rotateBackups() error {
+	path := s.path()
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	for i := JSONStoreBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backupPath(path, i), backupPath(path, i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	// link, rather than rename, the current database, so there's always a database, even if we crash before write completes.
+	backup := backupPath(path, 1)
+	if err := os.Remove(backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(path, backup); err != nil {
+		// not all filesystems support hard links
+		return copyFile(path, backup)
+	}
+	return nil
+}
+
+// write atomically writes the store to disk: it writes the stargazers to a temporary file and then renames it
+// to the database. A crash leaves either the old or the new version of the database, but never a partial one.
+func (s *JSONStore) write() error {
+	return writeJSONFile(s.path(), jsonDatabase{Version: jsonVersion, Stargazers: flattenedStargazers(s.stargazers)})
+}
+
+// writeJSONFile atomically replaces the file at path with v, encoded as JSON: the file either holds
+// the previous content or the new one, even if the process crashes while writing.
+func writeJSONFile(path string, v any) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(v); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("encode: %w", err)
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("sync: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	// persist the rename. Not all platforms support syncing a directory, so this is best effort.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Add adds new stargazers to a repository.
+// Returns the new stargazers and an error if there was a problem saving the store to disk.
+func (s *JSONStore) Add(stargazers ...github.Stargazer) ([]github.Stargazer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	added := make([]github.Stargazer, 0, len(stargazers))
+	for _, star := range withoutTopics(stargazers) {
+		if _, ok := s.stargazers[star.RepoName]; !ok {
+			s.stargazers[star.RepoName] = make(map[string]github.Stargazer)
+		}
+		if _, ok := s.stargazers[star.RepoName][star.Login]; !ok {
+			s.stargazers[star.RepoName][star.Login] = star
+			added = append(added, star)
+		}
+	}
+	var err error
+	if len(added) > 0 {
+		err = s.save()
+	}
+	return added, err
+}
+
+// Delete removes stargazers from a repository.
+// Returns the removed stargazers and an error if there was a problem saving the store to disk.
+func (s *JSONStore) Delete(stargazer ...github.Stargazer) ([]github.Stargazer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	removed := make([]github.Stargazer, 0, len(stargazer))
+	for _, star := range stargazer {
+		if _, ok := s.stargazers[star.RepoName]; !ok {
+			continue
+		}
+		if _, ok := s.stargazers[star.RepoName][star.Login]; !ok {
+			continue
+		}
+		delete(s.stargazers[star.RepoName], star.Login)
+		removed = append(removed, star)
+	}
+	return removed, s.save()
+}
+
+// Set updates the store to exactly match the provided stargazers per repository.
+// It returns the stargazers that were added and those that were removed.
+func (s *JSONStore) Set(stargazers []github.Stargazer) ([]github.Stargazer, []github.Stargazer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Build desired state: repo -> login -> RepoStar
+	desired := indexedStargazers(withoutTopics(stargazers))
+	added := repoDiff(desired, s.stargazers)
+	removed := repoDiff(s.stargazers, desired)
+	s.stargazers = desired
+	if err := s.save(); err != nil {
+		return nil, nil, err
+	}
+	return added, removed, nil
+}
+
+// withoutTopics returns the stargazers without their repository topics, which the store doesn't keep.
+func withoutTopics(stargazers []github.Stargazer) []github.Stargazer {
+	out := make([]github.Stargazer, len(stargazers))
+	for i, star := range stargazers {
+		star.RepoTopics = nil
+		out[i] = star
+	}
+	return out
+}
+
+// Stargazers returns the stargazers of a repository, or of all repositories if repo is blank.
+func (s *JSONStore) Stargazers(repo string) ([]github.Stargazer, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var stargazers []github.Stargazer
+	if repo == "" {
+		stargazers = flattenedStargazers(s.stargazers)
+	} else {
+		for _, star := range s.stargazers[repo] {
+			stargazers = append(stargazers, star)
+		}
+	}
+	sortStargazers(stargazers)
+	return stargazers, nil
+}
+
+// Close implements Store. JSONStore saves every change immediately, so Close has nothing to do.
+func (s *JSONStore) Close() error {
+	return nil
+}
+
+// AddEvents appends the Events to the event history.
+func (s *JSONStore) AddEvents(events ...Event) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+	}
+
+	s.eventsLock.Lock()
+	defer s.eventsLock.Unlock()
+	f, err := os.OpenFile(filepath.Join(s.databasePath, EventsFilename), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	// if we crashed while adding Events, make sure the new Events don't get appended to the incomplete line
+	data := buf.Bytes()
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err = f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+		}
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	return errors.Join(err, f.Close())
+}
+
+// Events returns the Events that match the filter, in the order they were added.
+//
+// If we crashed while adding Events, the last line of the event history may be incomplete. Events skips any lines
+// that it can't decode.
+func (s *JSONStore) Events(filter EventFilter) ([]Event, error) {
+	s.eventsLock.Lock()
+	defer s.eventsLock.Unlock()
+	f, err := os.Open(filepath.Join(s.databasePath, EventsFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Event
+		if err = json.Unmarshal(scanner.Bytes(), &event); err == nil && filter.match(event) {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
@@ -0,0 +1,108 @@
+package stars
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONStore_Backups(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewJSONStore(tmpDir)
+	require.NoError(t, err)
+
+	for i := range JSONStoreBackups + 2 {
+		_, err = store.Add(github.Stargazer{RepoName: "foo/bar", Login: "user" + strconv.Itoa(i)})
+		require.NoError(t, err)
+	}
+
+	// each backup holds the previous version of the database
+	path := filepath.Join(tmpDir, StoreFilename)
+	for i := 1; i <= JSONStoreBackups; i++ {
+		stargazers, _, err := loadStargazers(backupPath(path, i))
+		require.NoError(t, err)
+		assert.Len(t, stargazers, JSONStoreBackups+2-i)
+	}
+	assert.NoFileExists(t, backupPath(path, JSONStoreBackups+1))
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1+JSONStoreBackups)
+}
+
+func TestNewJSONStore_Recovery(t *testing.T) {
+	tests := []struct {
+		name              string
+		corrupt           []int
+		wantErr           assert.ErrorAssertionFunc
+		wantRecoveredFrom int
+		wantStargazers    int
+	}{
+		{name: "valid", wantErr: assert.NoError, wantStargazers: 3},
+		{name: "corrupt database", corrupt: []int{0}, wantErr: assert.NoError, wantRecoveredFrom: 1, wantStargazers: 2},
+		{name: "corrupt backup", corrupt: []int{0, 1}, wantErr: assert.NoError, wantRecoveredFrom: 2, wantStargazers: 1},
+		{name: "all corrupt", corrupt: []int{0, 1, 2, 3}, wantErr: assert.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			store, err := NewJSONStore(tmpDir)
+			require.NoError(t, err)
+			for i := range 3 {
+				_, err = store.Add(github.Stargazer{RepoName: "foo/bar", Login: "user" + strconv.Itoa(i)})
+				require.NoError(t, err)
+			}
+
+			// 0 is the database itself. 1 and up are the backups.
+			path := filepath.Join(tmpDir, StoreFilename)
+			for _, n := range tt.corrupt {
+				target := path
+				if n > 0 {
+					target = backupPath(path, n)
+				}
+				require.NoError(t, os.WriteFile(target, []byte(`[{"repo_name": "foo/bar", "lo`), 0o644))
+			}
+
+			store, err = NewJSONStore(tmpDir)
+			tt.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			stargazers, err := store.Stargazers("")
+			require.NoError(t, err)
+			assert.Len(t, stargazers, tt.wantStargazers)
+
+			if tt.wantRecoveredFrom == 0 {
+				assert.Empty(t, store.RecoveredFrom())
+				return
+			}
+			assert.Equal(t, backupPath(path, tt.wantRecoveredFrom), store.RecoveredFrom())
+			// the database is restored
+			stargazers, _, err = loadStargazers(path)
+			require.NoError(t, err)
+			assert.Len(t, stargazers, tt.wantStargazers)
+		})
+	}
+}
+
+func TestJSONStore_Events_Incomplete(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewJSONStore(tmpDir)
+	require.NoError(t, err)
+
+	// simulate a crash while adding an event
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, EventsFilename), []byte(`{"time":"2024-11-20T08:00:00Z","type":"star`), 0o644))
+
+	event := Event{Type: EventStarred, Source: SourceWebhook, Stargazer: github.Stargazer{RepoName: "foo/bar", Login: "user1"}}
+	require.NoError(t, store.AddEvents(event))
+	events, err := store.Events(EventFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []Event{event}, events)
+}
@@ -0,0 +1,271 @@
+package stars
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteFilename is the name of the database of a SQLiteStore.
+const SQLiteFilename = "stargazers.db"
+
+// sqliteTimeFormat is the format of timestamps in the database. All timestamps are stored in UTC, with a fixed
+// number of digits, so they can be compared as strings.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// sqliteTime formats a timestamp for the database.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// SQLiteStore is a Store that keeps the stargazers in a SQLite database. Unlike JSONStore, changes only update
+// the affected records, so it scales to repositories with many stargazers.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+// NewSQLiteStore opens the SQLiteStore in the database directory, creating it if it doesn't exist.
+// If the database was created by an older version, NewSQLiteStore upgrades it.
+func NewSQLiteStore(databasePath string) (*SQLiteStore, error) {
+	path := filepath.Join(databasePath, SQLiteFilename)
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	// SQLite only supports one writer at a time
+	db.SetMaxOpenConns(1)
+	if err = migrateSQLite(db, path); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// Add adds new stargazers to a repository.
+// Returns the new stargazers.
+func (s *SQLiteStore) Add(stargazers ...github.Stargazer) ([]github.Stargazer, error) {
+	added := make([]github.Stargazer, 0, len(stargazers))
+	err := inTx(s.db, func(tx *sql.Tx) error {
+		for _, star := range stargazers {
+			ok, err := exec(tx, `INSERT INTO stargazers (repo_name, login, repo_html_url, user_html_url, starred_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+				star.RepoName, star.Login, star.RepoHTMLURL, star.UserHTMLURL, sqliteTime(star.StarredAt),
+			)
+			if err != nil {
+				return err
+			}
+			if ok {
+				added = append(added, star)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// Delete removes stargazers from a repository.
+// Returns the removed stargazers.
+func (s *SQLiteStore) Delete(stargazers ...github.Stargazer) ([]github.Stargazer, error) {
+	removed := make([]github.Stargazer, 0, len(stargazers))
+	err := inTx(s.db, func(tx *sql.Tx) error {
+		for _, star := range stargazers {
+			ok, err := exec(tx, `DELETE FROM stargazers WHERE repo_name = ? AND login = ?`, star.RepoName, star.Login)
+			if err != nil {
+				return err
+			}
+			if ok {
+				removed = append(removed, star)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// Set updates the store to exactly match the provided stargazers per repository.
+// It returns the stargazers that were added and those that were removed.
+func (s *SQLiteStore) Set(stargazers []github.Stargazer) ([]github.Stargazer, []github.Stargazer, error) {
+	var added, removed []github.Stargazer
+	err := inTx(s.db, func(tx *sql.Tx) error {
+		current, err := query(tx, `SELECT repo_name, login, repo_html_url, user_html_url, starred_at FROM stargazers`)
+		if err != nil {
+			return err
+		}
+		currentIndex := indexedStargazers(current)
+		desired := indexedStargazers(stargazers)
+		added = repoDiff(desired, currentIndex)
+		removed = repoDiff(currentIndex, desired)
+		for _, star := range removed {
+			if _, err = exec(tx, `DELETE FROM stargazers WHERE repo_name = ? AND login = ?`, star.RepoName, star.Login); err != nil {
+				return err
+			}
+		}
+		// write the new stargazers, and any existing ones whose details changed
+		for repo, users := range desired {
+			for login, star := range users {
+				if old, ok := currentIndex[repo][login]; ok && sameStargazer(old, star) {
+					continue
+				}
+				if _, err = exec(tx, `INSERT OR REPLACE INTO stargazers (repo_name, login, repo_html_url, user_html_url, starred_at) VALUES (?, ?, ?, ?, ?)`,
+					star.RepoName, star.Login, star.RepoHTMLURL, star.UserHTMLURL, sqliteTime(star.StarredAt),
+				); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return added, removed, nil
+}
+
+// Stargazers returns the stargazers of a repository, or of all repositories if repo is blank.
+func (s *SQLiteStore) Stargazers(repo string) ([]github.Stargazer, error) {
+	const stmt = `SELECT repo_name, login, repo_html_url, user_html_url, starred_at FROM stargazers`
+	if repo == "" {
+		return query(s.db, stmt+` ORDER BY repo_name, login`)
+	}
+	return query(s.db, stmt+` WHERE repo_name = ? ORDER BY login`, repo)
+}
+
+// AddEvents appends the Events to the event history.
+func (s *SQLiteStore) AddEvents(events ...Event) error {
+	return inTx(s.db, func(tx *sql.Tx) error {
+		for _, event := range events {
+			if _, err := exec(tx, `INSERT INTO events (time, type, source, repo_name, login, repo_html_url, user_html_url, starred_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				sqliteTime(event.Time), event.Type, event.Source,
+				event.Stargazer.RepoName, event.Stargazer.Login, event.Stargazer.RepoHTMLURL, event.Stargazer.UserHTMLURL, sqliteTime(event.Stargazer.StarredAt),
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Events returns the Events that match the filter, in the order they were added.
+func (s *SQLiteStore) Events(filter EventFilter) ([]Event, error) {
+	var conditions []string
+	var args []any
+	add := func(condition string, arg any) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if !filter.Since.IsZero() {
+		add("time >= ?", sqliteTime(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		add("time < ?", sqliteTime(filter.Until))
+	}
+	if filter.Repo != "" {
+		add("repo_name = ?", filter.Repo)
+	}
+	if filter.Login != "" {
+		add("login = ?", filter.Login)
+	}
+	if filter.Type != "" {
+		add("type = ?", filter.Type)
+	}
+	if filter.Source != "" {
+		add("source = ?", filter.Source)
+	}
+	stmt := `SELECT time, type, source, repo_name, login, repo_html_url, user_html_url, starred_at FROM events`
+	if len(conditions) > 0 {
+		stmt += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	rows, err := s.db.Query(stmt+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var events []Event
+	for rows.Next() {
+		var event Event
+		var eventTime, starredAt string
+		if err = rows.Scan(&eventTime, &event.Type, &event.Source,
+			&event.Stargazer.RepoName, &event.Stargazer.Login, &event.Stargazer.RepoHTMLURL, &event.Stargazer.UserHTMLURL, &starredAt,
+		); err != nil {
+			return nil, err
+		}
+		if event.Time, err = time.Parse(time.RFC3339Nano, eventTime); err != nil {
+			return nil, fmt.Errorf("time: %w", err)
+		}
+		if event.Stargazer.StarredAt, err = time.Parse(time.RFC3339Nano, starredAt); err != nil {
+			return nil, fmt.Errorf("starred_at: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// inTx runs f in a transaction on the database. If f fails, the transaction is rolled back.
+func inTx(db *sql.DB, f func(*sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	if err = f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// exec executes the statement and reports whether it changed any rows.
+func exec(tx *sql.Tx, stmt string, args ...any) (bool, error) {
+	result, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// query returns the stargazers returned by the query.
+func query(q interface {
+	Query(string, ...any) (*sql.Rows, error)
+}, stmt string, args ...any) ([]github.Stargazer, error) {
+	rows, err := q.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var stargazers []github.Stargazer
+	for rows.Next() {
+		var star github.Stargazer
+		var starredAt string
+		if err = rows.Scan(&star.RepoName, &star.Login, &star.RepoHTMLURL, &star.UserHTMLURL, &starredAt); err != nil {
+			return nil, err
+		}
+		if star.StarredAt, err = time.Parse(time.RFC3339Nano, starredAt); err != nil {
+			return nil, fmt.Errorf("starred_at: %w", err)
+		}
+		stargazers = append(stargazers, star)
+	}
+	return stargazers, rows.Err()
+}
+
+// sameStargazer reports whether two stargazers have the same details.
+func sameStargazer(a, b github.Stargazer) bool {
+	return a.RepoName == b.RepoName && a.Login == b.Login && a.RepoHTMLURL == b.RepoHTMLURL &&
+		a.UserHTMLURL == b.UserHTMLURL && a.StarredAt.Equal(b.StarredAt)
+}
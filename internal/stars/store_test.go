@@ -2,10 +2,13 @@ package stars
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"slices"
 	"sync"
 	"testing"
@@ -18,57 +21,130 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// storeBackends creates a Store of each type in the database directory.
+var storeBackends = map[string]func(string) (Store, error){
+	"json":   func(dir string) (Store, error) { return NewJSONStore(dir) },
+	"sqlite": func(dir string) (Store, error) { return NewSQLiteStore(dir) },
+}
+
 func TestStore(t *testing.T) {
-	tmpDir := t.TempDir()
-	store, err := NewStore(tmpDir)
-	require.NoError(t, err)
+	for name, newStore := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			store, err := newStore(tmpDir)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = store.Close() })
 
-	toAdd := []github.Stargazer{
-		{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "foo/bar", Login: "user1"},
-		{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "foo/bar", Login: "user2"},
-	}
+			toAdd := []github.Stargazer{
+				{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "foo/bar", Login: "user1"},
+				{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "foo/bar", Login: "user2"},
+			}
 
-	// Add the new stargazers. All should be added
-	added, err := store.Add(toAdd...)
-	require.NoError(t, err)
-	require.Equal(t, toAdd, added)
+			// Add the new stargazers. All should be added
+			added, err := store.Add(toAdd...)
+			require.NoError(t, err)
+			require.Equal(t, toAdd, added)
 
-	// Add the same stargazers again. Nothing should change
-	added, err = store.Add(toAdd...)
-	require.NoError(t, err)
-	require.Empty(t, added)
+			// Add the same stargazers again. Nothing should change
+			added, err = store.Add(toAdd...)
+			require.NoError(t, err)
+			require.Empty(t, added)
 
-	// Load a copy. Add the same stargazers again. Nothing should change
-	store2, err := NewStore(tmpDir)
-	require.NoError(t, err)
-	added, err = store2.Add(toAdd...)
-	require.NoError(t, err)
-	require.Empty(t, added)
+			// Load a copy. Add the same stargazers again. Nothing should change
+			store2, err := newStore(tmpDir)
+			require.NoError(t, err)
+			added, err = store2.Add(toAdd...)
+			require.NoError(t, err)
+			require.Empty(t, added)
+			require.NoError(t, store2.Close())
 
-	// Delete the stargazers
-	deleted, err := store.Delete(toAdd...)
-	require.NoError(t, err)
-	require.Equal(t, toAdd, deleted)
+			// Query the stargazers
+			stargazers, err := store.Stargazers("foo/bar")
+			require.NoError(t, err)
+			assert.Equal(t, toAdd, stargazers)
+			stargazers, err = store.Stargazers("foo/baz")
+			require.NoError(t, err)
+			assert.Empty(t, stargazers)
 
-	// Delete the stargazers again. Nothing should change
-	deleted, err = store.Delete(toAdd...)
-	require.NoError(t, err)
-	require.Empty(t, deleted)
+			// Delete the stargazers
+			deleted, err := store.Delete(toAdd...)
+			require.NoError(t, err)
+			require.Equal(t, toAdd, deleted)
 
-	// reset the store to its initial state
-	_, err = store.Add(toAdd...)
-	require.NoError(t, err)
+			// Delete the stargazers again. Nothing should change
+			deleted, err = store.Delete(toAdd...)
+			require.NoError(t, err)
+			require.Empty(t, deleted)
 
-	// Set the stargazers with new records.
-	added, deleted, err = store.Set([]github.Stargazer{
-		{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "foo/bar", Login: "user1"},
-		{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "foo/bar", Login: "user3"},
-	})
-	require.NoError(t, err)
-	require.Len(t, added, 1)
-	require.Len(t, deleted, 1)
-	require.Equal(t, "user3", added[0].Login)
-	require.Equal(t, "user2", deleted[0].Login)
+			// reset the store to its initial state
+			_, err = store.Add(toAdd...)
+			require.NoError(t, err)
+
+			// Set the stargazers with new records.
+			want := []github.Stargazer{
+				{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "foo/bar", Login: "user1", UserHTMLURL: "https://example.com/user1"},
+				{StarredAt: time.Date(2024, time.November, 20, 8, 0, 0, 0, time.UTC), RepoName: "foo/bar", Login: "user3"},
+			}
+			added, deleted, err = store.Set(want)
+			require.NoError(t, err)
+			require.Len(t, added, 1)
+			require.Len(t, deleted, 1)
+			require.Equal(t, "user3", added[0].Login)
+			require.Equal(t, "user2", deleted[0].Login)
+
+			// Set also updates the details of existing stargazers
+			stargazers, err = store.Stargazers("")
+			require.NoError(t, err)
+			assert.Equal(t, want, stargazers)
+		})
+	}
+}
+
+func TestStore_Events(t *testing.T) {
+	start := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	user1 := github.Stargazer{StarredAt: start, RepoName: "foo/bar", Login: "user1"}
+	user2 := github.Stargazer{StarredAt: start, RepoName: "foo/baz", Login: "user2"}
+	events := []Event{
+		{Time: start, Type: EventStarred, Source: SourceScan, Stargazer: user1},
+		{Time: start.Add(time.Hour), Type: EventStarred, Source: SourceWebhook, Stargazer: user2},
+		{Time: start.Add(30 * 24 * time.Hour), Type: EventUnstarred, Source: SourceWebhook, Stargazer: user1},
+		{Time: start.Add(31 * 24 * time.Hour), Type: EventStarred, Source: SourceScan, Stargazer: user1},
+	}
+	tests := []struct {
+		name   string
+		filter EventFilter
+		want   []Event
+	}{
+		{name: "all", want: events},
+		{name: "repo", filter: EventFilter{Repo: "foo/bar"}, want: []Event{events[0], events[2], events[3]}},
+		{name: "login", filter: EventFilter{Login: "user2"}, want: []Event{events[1]}},
+		{name: "type", filter: EventFilter{Type: EventUnstarred}, want: []Event{events[2]}},
+		{name: "period", filter: EventFilter{Since: start.Add(time.Hour), Until: start.Add(31 * 24 * time.Hour)}, want: []Event{events[1], events[2]}},
+		{name: "none", filter: EventFilter{Repo: "foo/qux"}},
+	}
+
+	for name, newStore := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			store, err := newStore(t.TempDir())
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = store.Close() })
+
+			got, err := store.Events(EventFilter{})
+			require.NoError(t, err)
+			assert.Empty(t, got)
+
+			require.NoError(t, store.AddEvents(events[:2]...))
+			require.NoError(t, store.AddEvents(events[2:]...))
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					got, err := store.Events(tt.filter)
+					require.NoError(t, err)
+					assert.Equal(t, tt.want, got)
+				})
+			}
+		})
+	}
 }
 
 func TestNotifyingStore(t *testing.T) {
@@ -80,8 +156,7 @@ func TestNotifyingStore(t *testing.T) {
 		SlogNotifier{},
 		SlackNotifier{WebHookURL: ts.URL},
 	}
-	store, err := NewNotifyingStore(t.TempDir(), notifiers)
-	require.NoError(t, err)
+	store := newTestStore(t, notifiers)
 
 	var buf bytes.Buffer
 	ctx := slogctx.NewWithContext(t.Context(), slogWithoutTime(&buf, slog.LevelInfo))
@@ -103,6 +178,157 @@ level=INFO msg="repo lost 1 stargazers" repo=foo/bar
 		"Repo <https://example.com/foo/bar|foo/bar> lost a star from <https://example.com/user1|@user1>",
 	}
 	assert.Equal(t, wantSlack, s.received())
+
+	events, err := store.Events(EventFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, EventStarred, events[0].Type)
+	assert.Equal(t, EventUnstarred, events[1].Type)
+	assert.Equal(t, SourceWebhook, events[1].Source)
+	assert.Equal(t, toAdd[0], events[1].Stargazer)
+}
+
+func TestNotifyingStore_NotifyFailure(t *testing.T) {
+	n := fakeNotifier{failures: 1}
+	store := newTestStore(t, Notifiers{&n})
+
+	// the stargazer is still added, and the next notification succeeds
+	user1 := github.Stargazer{RepoName: "foo/bar", Login: "user1"}
+	assert.ErrorContains(t, store.Add(t.Context(), user1), "notify: failed")
+	stargazers, err := store.Stargazers("foo/bar")
+	require.NoError(t, err)
+	assert.Equal(t, []github.Stargazer{user1}, stargazers)
+	assert.NoError(t, store.Delete(t.Context(), user1))
+	assert.Len(t, n.delivered(), 1)
+}
+
+func TestNotifyingStore_Milestones(t *testing.T) {
+	var n fakeNotifier
+	store := newTestStore(t, Notifiers{&n})
+	var err error
+	store.Milestones, err = NewMilestoneTracker(Milestones{Counts: []int{2}}, filepath.Join(t.TempDir(), MilestonesFilename))
+	require.NoError(t, err)
+
+	user1 := github.Stargazer{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user1"}
+	user2 := github.Stargazer{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user2"}
+	require.NoError(t, store.Add(t.Context(), user1))
+	assert.Empty(t, n.reached())
+	require.NoError(t, store.Add(t.Context(), user2))
+	assert.Equal(t, map[string]int{"foo/bar": 2}, n.reached())
+
+	// losing and regaining a star doesn't reach the milestone again
+	n.milestones = nil
+	require.NoError(t, store.Delete(t.Context(), user2))
+	require.NoError(t, store.Set(t.Context(), []github.Stargazer{user1, user2}))
+	assert.Empty(t, n.reached())
+}
+
+func TestNotifyingStore_Milestones_NewStore(t *testing.T) {
+	var n fakeNotifier
+	store := newTestStore(t, Notifiers{&n})
+	var err error
+	store.Milestones, err = NewMilestoneTracker(Milestones{Counts: []int{2}}, filepath.Join(t.TempDir(), MilestonesFilename))
+	require.NoError(t, err)
+
+	// the first scan finds stars that the repository gained long ago: they don't reach a milestone
+	user1 := github.Stargazer{RepoName: "foo/bar", Login: "user1"}
+	user2 := github.Stargazer{RepoName: "foo/bar", Login: "user2"}
+	user3 := github.Stargazer{RepoName: "foo/bar", Login: "user3"}
+	require.NoError(t, store.Set(t.Context(), []github.Stargazer{user1, user2, user3}))
+	assert.Len(t, n.delivered(), 1)
+	assert.Empty(t, n.reached())
+}
+
+func TestNotifyingStore_Topics(t *testing.T) {
+	for name, newStore := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			user1 := github.Stargazer{RepoName: "foo/bar", Login: "user1", RepoTopics: []string{"go"}}
+			user2 := github.Stargazer{RepoName: "foo/bar", Login: "user2", RepoTopics: []string{"go"}}
+
+			backend, err := newStore(tmpDir)
+			require.NoError(t, err)
+			var n fakeNotifier
+			store := NewNotifyingStore(backend, Notifiers{&n})
+			require.NoError(t, store.Set(t.Context(), []github.Stargazer{user1, user2}))
+			require.NoError(t, backend.Close())
+			require.Len(t, n.delivered(), 1)
+			assert.Equal(t, []string{"go"}, n.delivered()[0][0].RepoTopics)
+
+			// after a restart, the stars that a scan removes still have the repository's topics
+			backend, err = newStore(tmpDir)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = backend.Close() })
+			n = fakeNotifier{}
+			store = NewNotifyingStore(backend, Notifiers{&n})
+			require.NoError(t, store.Set(t.Context(), []github.Stargazer{user1}))
+			require.Len(t, n.delivered(), 1)
+			assert.Equal(t, "user2", n.delivered()[0][0].Login)
+			assert.Equal(t, []string{"go"}, n.delivered()[0][0].RepoTopics)
+
+			// the topics aren't stored
+			stargazers, err := store.Stargazers("foo/bar")
+			require.NoError(t, err)
+			require.Len(t, stargazers, 1)
+			assert.Empty(t, stargazers[0].RepoTopics)
+			events, err := store.Events(EventFilter{})
+			require.NoError(t, err)
+			for _, event := range events {
+				assert.Empty(t, event.Stargazer.RepoTopics)
+			}
+		})
+	}
+}
+
+func TestNotifyingStore_Reconcile(t *testing.T) {
+	store := newTestStore(t, nil)
+	ctx := t.Context()
+
+	user1 := github.Stargazer{RepoName: "foo/bar", Login: "user1"}
+	user2 := github.Stargazer{RepoName: "foo/bar", Login: "user2"}
+	user3 := github.Stargazer{RepoName: "foo/bar", Login: "user3"}
+	require.NoError(t, store.Add(ctx, user1, user2))
+
+	// while the scan is running, user3 stars the repo and user2 removes their star.
+	// the scan doesn't see these changes.
+	err := store.Reconcile(ctx, func(ctx context.Context) ([]github.Stargazer, error) {
+		require.NoError(t, store.Add(ctx, user3))
+		require.NoError(t, store.Delete(ctx, user2))
+		return []github.Stargazer{user1, user2}, nil
+	})
+	require.NoError(t, err)
+
+	// the changes made during the scan must not be undone
+	want := []github.Stargazer{user1, user3}
+	got, err := store.Stargazers("")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// a failed fetch leaves the store untouched
+	require.Error(t, store.Reconcile(ctx, func(ctx context.Context) ([]github.Stargazer, error) {
+		return nil, errors.New("failed")
+	}))
+	got, err = store.Stargazers("")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// changes found by the scan are recorded as such
+	require.NoError(t, store.Reconcile(ctx, func(ctx context.Context) ([]github.Stargazer, error) {
+		return []github.Stargazer{user3}, nil
+	}))
+	events, err := store.Events(EventFilter{Source: SourceScan})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventUnstarred, events[0].Type)
+	assert.Equal(t, user1, events[0].Stargazer)
+}
+
+// newTestStore returns a NotifyingStore backed by a JSONStore in a temporary directory.
+func newTestStore(t *testing.T, notifiers Notifiers) *NotifyingStore {
+	t.Helper()
+	store, err := NewJSONStore(t.TempDir())
+	require.NoError(t, err)
+	return NewNotifyingStore(store, notifiers)
 }
 
 type fakeSlackWebhook struct {
@@ -0,0 +1,244 @@
+package stars
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/clambin/github-stars/internal/github"
+)
+
+// MessageKind is the kind of event that a message notifies about.
+type MessageKind string
+
+const (
+	MessageAdded     MessageKind = "added"
+	MessageRemoved   MessageKind = "removed"
+	MessageMilestone MessageKind = "milestone"
+	MessageDigest    MessageKind = "digest"
+)
+
+func messageKind(added bool) MessageKind {
+	if added {
+		return MessageAdded
+	}
+	return MessageRemoved
+}
+
+// MessageData is the data that Templates render a message from.
+type MessageData struct {
+	// Repo is the full name of the repository
+	Repo string
+	// RepoURL is the URL of the repository
+	RepoURL string
+	// Stargazers are the added/removed stargazers
+	Stargazers []github.Stargazer
+	// MaximumUsers is the maximum number of stargazers that the message should list
+	MaximumUsers int
+	// Milestone is the number of stars that the repository reached. Milestone messages only.
+	Milestone int
+	// Digest is the summary of the stars that repositories gained and lost. Digest messages only.
+	Digest Digest
+	// Added is true if the stargazers were added, or false if they were removed
+	Added bool
+}
+
+func newMessageData(gazers []github.Stargazer, added bool, maximumUsers int) MessageData {
+	data := MessageData{Stargazers: gazers, Added: added, MaximumUsers: maximumUsers}
+	if len(gazers) > 0 {
+		data.Repo, data.RepoURL = gazers[0].RepoName, gazers[0].RepoHTMLURL
+	}
+	return data
+}
+
+func newMilestoneData(repo github.Stargazer, milestone int) MessageData {
+	return MessageData{Repo: repo.RepoName, RepoURL: repo.RepoHTMLURL, Milestone: milestone, Added: true}
+}
+
+func newDigestData(digest Digest) MessageData {
+	return MessageData{Digest: digest}
+}
+
+// defaultTemplates are the messages that notifiers send if no template was configured.
+const defaultTemplates = `
+{{- define "added" }}Repo {{ link .RepoURL .Repo }} received a star from {{ template "users" . }}{{ end }}
+{{- define "removed" }}Repo {{ link .RepoURL .Repo }} lost a star from {{ template "users" . }}{{ end }}
+{{- define "milestone" }}Repo {{ link .RepoURL .Repo }} reached {{ count .Milestone "star" "stars" }}{{ end }}
+{{- define "digest" }}{{ escape (printf "GitHub stars from %s to %s:" (.Digest.From.Format "Jan 2 15:04") (.Digest.To.Format "Jan 2 15:04")) }}
+	{{- range .Digest.Repos }}
+{{ link .RepoURL .Repo }}{{ escape (printf ": +%d, -%d (%s)" .Added .Removed (count .Stars "star" "stars")) }}
+	{{- end }}
+{{- end }}
+{{- define "users" }}{{ $n := len .Stargazers }}
+	{{- if gt $n 1 }}{{ $n }} users{{ if le $n .MaximumUsers }}: {{ end }}{{ end }}
+	{{- if le $n .MaximumUsers }}{{ range $i, $s := .Stargazers }}{{ if $i }}, {{ end }}{{ user $s }}{{ end }}{{ end }}
+{{- end }}
+{{- define "discord.added" }}Repo received a star from {{ template "users" . }}{{ end }}
+{{- define "discord.removed" }}Repo lost a star from {{ template "users" . }}{{ end }}
+{{- define "discord.milestone" }}Repo reached {{ count .Milestone "star" "stars" }}{{ end }}
+{{- define "teams.added" }}Repo received a star from {{ template "users" . }}{{ end }}
+{{- define "teams.removed" }}Repo lost a star from {{ template "users" . }}{{ end }}
+{{- define "teams.milestone" }}Repo reached {{ count .Milestone "star" "stars" }}{{ end }}
+{{- define "email.added" }}Repo {{ link .RepoURL .Repo }} received a star from:{{ template "email.users" . }}{{ end }}
+{{- define "email.removed" }}Repo {{ link .RepoURL .Repo }} lost a star from:{{ template "email.users" . }}{{ end }}
+{{- define "email.users" }}{{ range .Stargazers }}
+- {{ user . }}{{ end }}{{ end }}
+{{- define "email.subject.added" }}{{ .Repo }} received a star from {{ template "email.subject.users" . }}{{ end }}
+{{- define "email.subject.removed" }}{{ .Repo }} lost a star from {{ template "email.subject.users" . }}{{ end }}
+{{- define "email.subject.milestone" }}Repo {{ .Repo }} reached {{ count .Milestone "star" "stars" }}{{ end }}
+{{- define "email.subject.digest" }}GitHub stars: {{ .Digest.Added }} new, {{ .Digest.Removed }} lost{{ end }}
+{{- define "email.subject.users" }}{{ if eq (len .Stargazers) 1 }}{{ (index .Stargazers 0).Login }}{{ else }}{{ len .Stargazers }} users{{ end }}{{ end }}
+`
+
+// Templates renders the messages that notifiers send. Each message is a Go text/template, named after the kind of
+// message ("added", "removed", "milestone" or "digest"). To customize the message of a single notifier, prefix the name with
+// the notifier's name, e.g. "slack.added". The templates receive a MessageData.
+//
+// Besides the standard functions, templates can use:
+//
+//   - link URL TEXT: a link to URL, in the notifier's markup. If URL is blank, link returns TEXT.
+//   - user STARGAZER: a link to the stargazer's profile.
+//   - escape TEXT: TEXT, escaped for the notifier's markup.
+//   - plural N SINGULAR PLURAL: SINGULAR if N is 1, PLURAL otherwise.
+//   - count N SINGULAR PLURAL: N, followed by SINGULAR or PLURAL, e.g. "1 star" or "5 stars".
+//
+// Values inserted with these functions are escaped. Any other text must be valid in the notifier's markup.
+type Templates struct {
+	tmpl *template.Template
+	// user holds the names of the templates that were defined by the user
+	user map[string]struct{}
+}
+
+// defaultMessageTemplates renders the default messages.
+var defaultMessageTemplates = template.Must(template.New("").Funcs(markupFuncs(markupPlain)).Parse(defaultTemplates))
+
+// NewTemplates parses the templates in texts. A template in a text overrides a template with the same name in
+// a previous text. Any message that texts don't define uses the default template.
+func NewTemplates(texts ...string) (*Templates, error) {
+	// parse the templates over the default ones, so they can use default templates, like "users".
+	t := Templates{tmpl: template.Must(defaultMessageTemplates.Clone()), user: make(map[string]struct{})}
+	for _, text := range texts {
+		user, err := template.New("").Funcs(markupFuncs(markupPlain)).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		for _, tmpl := range user.Templates() {
+			if tmpl.Name() != "" {
+				t.user[tmpl.Name()] = struct{}{}
+			}
+		}
+		if _, err = t.tmpl.Parse(text); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// render renders the message of the given kind for a notifier, in the notifier's markup.
+// A nil Templates renders the default messages.
+func (t *Templates) render(notifier string, kind MessageKind, m markup, data MessageData) (string, error) {
+	return t.renderTemplate(t.lookup(notifier, kind), m, data)
+}
+
+// renderTemplate renders the template with the given name: the user's template, or else the default one.
+// Unlike render, it doesn't fall back to the template for all notifiers.
+func (t *Templates) renderTemplate(name string, m markup, data MessageData) (string, error) {
+	tmpl := defaultMessageTemplates
+	if t != nil {
+		tmpl = t.tmpl
+	}
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	err = tmpl.Funcs(markupFuncs(m)).ExecuteTemplate(&out, name, data)
+	return out.String(), err
+}
+
+// lookup returns the name of the template to render. Templates defined by the user take precedence over
+// the default ones, and templates for a notifier take precedence over those for all notifiers.
+func (t *Templates) lookup(notifier string, kind MessageKind) string {
+	names := []string{notifier + "." + string(kind), string(kind)}
+	if t != nil {
+		for _, name := range names {
+			if _, ok := t.user[name]; ok {
+				return name
+			}
+		}
+	}
+	for _, name := range names {
+		if defaultMessageTemplates.Lookup(name) != nil {
+			return name
+		}
+	}
+	return string(kind)
+}
+
+// markup is the formatting of a message.
+type markup int
+
+const (
+	markupPlain markup = iota
+	markupSlack
+	markupMarkdown
+	markupHTML
+	markupTelegramMarkdownV2
+)
+
+func markupFuncs(m markup) template.FuncMap {
+	escape, link := markupEscapers[m], markupLinks[m]
+	return template.FuncMap{
+		"escape": escape,
+		"link": func(href, text string) string {
+			if href == "" {
+				return escape(text)
+			}
+			return link(href, text)
+		},
+		"user": func(stargazer github.Stargazer) string {
+			if stargazer.UserHTMLURL == "" || m == markupPlain {
+				return escape(stargazer.Login)
+			}
+			return link(stargazer.UserHTMLURL, "@"+stargazer.Login)
+		},
+		"plural": plural,
+		"count": func(n int, one, many string) string {
+			return strconv.Itoa(n) + " " + plural(n, one, many)
+		},
+	}
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+var markupEscapers = map[markup]func(string) string{
+	markupPlain:              func(s string) string { return s },
+	markupSlack:              strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace,
+	markupMarkdown:           markdownEscape,
+	markupHTML:               html.EscapeString,
+	markupTelegramMarkdownV2: telegramMarkdownV2Escape,
+}
+
+// markdownEscape escapes the characters that format Markdown text (emphasis, code and links),
+// so that e.g. a login like user_name_ isn't rendered in italics.
+var markdownEscape = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `_`, `\_`, "`", "\\`", `[`, `\[`, `]`, `\]`).Replace
+
+var markupLinks = map[markup]func(href, text string) string{
+	markupPlain: func(_, text string) string { return text },
+	markupSlack: func(href, text string) string {
+		return "<" + href + "|" + markupEscapers[markupSlack](text) + ">"
+	},
+	markupMarkdown: func(href, text string) string {
+		return "[" + markdownEscape(text) + "](" + strings.NewReplacer(`\`, `\\`, `)`, `\)`).Replace(href) + ")"
+	},
+	markupHTML: func(href, text string) string {
+		return `<a href="` + html.EscapeString(href) + `">` + html.EscapeString(text) + `</a>`
+	},
+	markupTelegramMarkdownV2: telegramMarkdownV2Link,
+}
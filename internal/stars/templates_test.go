@@ -0,0 +1,102 @@
+package stars
+
+import (
+	"testing"
+	"time"
+
+	"github.com/clambin/github-stars/internal/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplates(t *testing.T) {
+	tmpl, err := NewTemplates(`
+{{- define "added" }}{{ .Repo }}: +{{ len .Stargazers }}{{ end }}
+{{- define "slack.added" }}{{ link .RepoURL .Repo }} got {{ count (len .Stargazers) "star" "stars" }}{{ end }}
+{{- define "milestone" }}{{ .Repo }} reached {{ .Milestone }} {{ plural .Milestone "star" "stars" }}{{ end }}
+`)
+	require.NoError(t, err)
+
+	gazers := []github.Stargazer{
+		{RepoName: "foo/bar", RepoHTMLURL: "https://example.com/foo/bar", Login: "user1", UserHTMLURL: "https://example.com/user1"},
+	}
+	tests := []struct {
+		name      string
+		templates *Templates
+		notifier  string
+		kind      MessageKind
+		markup    markup
+		data      MessageData
+		want      string
+	}{
+		{name: "default", notifier: "slack", kind: MessageAdded, markup: markupSlack, data: newMessageData(gazers, true, 5), want: "Repo <https://example.com/foo/bar|foo/bar> received a star from <https://example.com/user1|@user1>"},
+		{name: "default for notifier", notifier: "discord", kind: MessageRemoved, markup: markupMarkdown, data: newMessageData(gazers, false, 5), want: "Repo lost a star from [@user1](https://example.com/user1)"},
+		{name: "user-defined", templates: tmpl, notifier: "matrix", kind: MessageAdded, markup: markupHTML, data: newMessageData(gazers, true, 5), want: "foo/bar: +1"},
+		{name: "user-defined for notifier", templates: tmpl, notifier: "slack", kind: MessageAdded, markup: markupSlack, data: newMessageData(gazers, true, 5), want: "<https://example.com/foo/bar|foo/bar> got 1 star"},
+		{name: "user-defined overrides default for notifier", templates: tmpl, notifier: "discord", kind: MessageAdded, markup: markupMarkdown, data: newMessageData(gazers, true, 5), want: "foo/bar: +1"},
+		{name: "not user-defined", templates: tmpl, notifier: "slack", kind: MessageRemoved, markup: markupPlain, data: newMessageData(gazers, false, 5), want: "Repo foo/bar lost a star from user1"},
+		{name: "milestone", templates: tmpl, notifier: "slack", kind: MessageMilestone, markup: markupPlain, data: MessageData{Repo: "foo/bar", Milestone: 100}, want: "foo/bar reached 100 stars"},
+		{name: "default milestone", notifier: "telegram", kind: MessageMilestone, markup: markupTelegramMarkdownV2, data: MessageData{Repo: "foo/bar.go", RepoURL: "https://example.com/foo/bar.go", Milestone: 1}, want: `Repo [foo/bar\.go](https://example.com/foo/bar.go) reached 1 star`},
+		{name: "default digest", notifier: "telegram", kind: MessageDigest, markup: markupTelegramMarkdownV2, data: newDigestData(testDigest), want: "GitHub stars from Mar 3 09:00 to Mar 4 09:00:\n[foo/bar](https://example.com/foo/bar): \\+2, \\-1 \\(10 stars\\)\nfoo/baz: \\+1, \\-0 \\(1 star\\)"},
+		{name: "default digest for email subject", notifier: "email.subject", kind: MessageDigest, markup: markupPlain, data: newDigestData(testDigest), want: "GitHub stars: 3 new, 1 lost"},
+		{name: "default milestone for notifier", notifier: "teams", kind: MessageMilestone, markup: markupMarkdown, data: newMilestoneData(gazers[0], 1000), want: "Repo reached 1000 stars"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.templates.render(tt.notifier, tt.kind, tt.markup, tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewTemplates_Override(t *testing.T) {
+	tmpl, err := NewTemplates(`{{ define "added" }}one{{ end }}{{ define "removed" }}two{{ end }}`, `{{ define "added" }}three{{ end }}`)
+	require.NoError(t, err)
+	got, err := tmpl.render("slack", MessageAdded, markupPlain, MessageData{})
+	require.NoError(t, err)
+	assert.Equal(t, "three", got)
+	got, err = tmpl.render("slack", MessageRemoved, markupPlain, MessageData{})
+	require.NoError(t, err)
+	assert.Equal(t, "two", got)
+}
+
+func TestTemplates_Escape(t *testing.T) {
+	tmpl, err := NewTemplates(`{{ define "added" }}<b>{{ escape .Repo }}</b>{{ end }}`)
+	require.NoError(t, err)
+	got, err := tmpl.render("matrix", MessageAdded, markupHTML, MessageData{Repo: "<foo>"})
+	require.NoError(t, err)
+	assert.Equal(t, "<b>&lt;foo&gt;</b>", got)
+}
+
+func TestTemplates_EscapeMarkdown(t *testing.T) {
+	data := newMessageData([]github.Stargazer{{Login: "_user_", UserHTMLURL: "https://example.com/_user_"}, {Login: "*user*"}}, true, 5)
+	data.Repo, data.RepoURL = "foo/[bar]`", "https://example.com/foo/(bar)"
+	tmpl, err := NewTemplates(`{{ define "added" }}{{ link .RepoURL .Repo }}: {{ range .Stargazers }}{{ user . }} {{ end }}{{ end }}`)
+	require.NoError(t, err)
+	got, err := tmpl.render("discord", MessageAdded, markupMarkdown, data)
+	require.NoError(t, err)
+	assert.Equal(t, "[foo/\\[bar\\]\\`](https://example.com/foo/(bar\\)): [@\\_user\\_](https://example.com/_user_) \\*user\\* ", got)
+}
+
+func TestNewTemplates_Errors(t *testing.T) {
+	_, err := NewTemplates(`{{ define "added" }}{{ .Repo }`)
+	assert.Error(t, err)
+
+	_, err = NewTemplates(`{{ define "added" }}{{ unknown .Repo }}{{ end }}`)
+	assert.Error(t, err)
+
+	tmpl, err := NewTemplates(`{{ define "added" }}{{ .Unknown }}{{ end }}`)
+	require.NoError(t, err)
+	_, err = tmpl.render("slack", MessageAdded, markupPlain, MessageData{})
+	assert.Error(t, err)
+}
+
+var testDigest = Digest{
+	From: time.Date(2025, time.March, 3, 9, 0, 0, 0, time.UTC),
+	To:   time.Date(2025, time.March, 4, 9, 0, 0, 0, time.UTC),
+	Repos: []DigestRepo{
+		{Repo: "foo/bar", RepoURL: "https://example.com/foo/bar", Added: 2, Removed: 1, Stars: 10},
+		{Repo: "foo/baz", Added: 1, Stars: 1},
+	},
+}